@@ -0,0 +1,231 @@
+package sdhook
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logging "google.golang.org/api/logging/v2"
+)
+
+// Status is a snapshot of the hook's runtime state.
+type Status struct {
+	// Transports are the names of the transports or sinks log entries are
+	// delivered to.
+	Transports []string `json:"transports"`
+	// ProjectID is the project ID.
+	ProjectID string `json:"project_id,omitempty"`
+	// LogName is the log name.
+	LogName string `json:"log_name,omitempty"`
+	// ErrorReportingLogName is the error reporting log name.
+	ErrorReportingLogName string `json:"error_reporting_log_name,omitempty"`
+	// ErrorReportingService is the error reporting service name.
+	ErrorReportingService string `json:"error_reporting_service,omitempty"`
+	// Resource is the monitored resource.
+	Resource *logging.MonitoredResource `json:"resource,omitempty"`
+	// Levels are the levels.
+	Levels []string `json:"levels"`
+	// Labels are the common labels.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Sampling indicates whether sampling is set and enabled.
+	Sampling bool `json:"sampling"`
+	// QueueDepth is the number of fired logrus entries not yet delivered.
+	QueueDepth int64 `json:"queue_depth"`
+	// LastError is the last delivery error, if any.
+	LastError *LastError `json:"last_error,omitempty"`
+	// Closed indicates whether the hook was closed.
+	Closed bool `json:"closed"`
+	// Stats are the delivery statistics.
+	Stats Stats `json:"stats"`
+}
+
+// LastError is the last delivery error of a hook.
+type LastError struct {
+	// Time is the time of the error.
+	Time time.Time `json:"time"`
+	// Transport is the transport of the error.
+	Transport string `json:"transport"`
+	// Reason is the drop reason, if the log entry was dropped.
+	Reason string `json:"reason,omitempty"`
+	// Error is the error message.
+	Error string `json:"error"`
+}
+
+// lastError holds the last delivery error.
+type lastError struct {
+	mu  sync.Mutex
+	err *LastError
+}
+
+// set sets the last delivery error.
+func (l *lastError) set(err *DeliveryError) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.err = &LastError{
+		Time:      time.Now(),
+		Transport: err.Transport,
+		Reason:    err.Reason,
+		Error:     err.Error(),
+	}
+}
+
+// get returns the last delivery error.
+func (l *lastError) get() *LastError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// Status returns a snapshot of the hook's runtime state.
+func (h *Hook) Status() Status {
+	var transports []string
+	switch {
+	case len(h.sinks) != 0:
+		for _, s := range h.sinks {
+			transports = append(transports, s.sink.Name())
+		}
+	case h.agentClient != nil:
+		transports = []string{TransportAgent}
+	default:
+		transports = []string{TransportAPI}
+	}
+	stats := h.Stats()
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	levels := make([]string, len(h.levels))
+	for i, l := range h.levels {
+		levels[i] = l.String()
+	}
+	return Status{
+		Transports:            transports,
+		ProjectID:             h.projectID,
+		LogName:               h.logName,
+		ErrorReportingLogName: h.errorReportingLogName,
+		ErrorReportingService: h.errorReportingServiceName,
+		Resource:              h.resource,
+		Levels:                levels,
+		Labels:                h.labels,
+		Sampling:              h.sampler != nil && !h.samplingDisabled,
+		QueueDepth:            stats.QueueDepth,
+		LastError:             h.lastError.get(),
+		Closed:                h.closed.Load(),
+		Stats:                 stats,
+	}
+}
+
+// AdminHandler returns a http.Handler for inspecting and controlling the
+// hook, with the endpoints:
+//
+//	GET  /          the hook's status (see Status)
+//	POST /flush     delivers pending log entries (see Flush)
+//	POST /levels    changes the levels, from a JSON body such as
+//	                {"levels": ["info", "error"]}
+//	POST /sampling  enables or disables sampling, from a JSON body such as
+//	                {"enabled": false}
+//
+// Responses are JSON. Use http.StripPrefix to mount the handler on a path
+// other than the root. The handler must only be exposed to operators.
+func (h *Hook) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			adminError(w, http.StatusNotFound, errors.New("not found"))
+			return
+		}
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			adminError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		adminJSON(w, http.StatusOK, h.Status())
+	})
+	mux.HandleFunc("/flush", adminPost(func(w http.ResponseWriter, req *http.Request) {
+		if err := h.Flush(req.Context()); err != nil {
+			adminError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		adminJSON(w, http.StatusOK, h.Status())
+	}))
+	mux.HandleFunc("/levels", adminPost(func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Levels []string `json:"levels"`
+		}
+		if err := adminDecode(req, &body); err != nil {
+			adminError(w, http.StatusBadRequest, err)
+			return
+		}
+		levels := make([]logrus.Level, len(body.Levels))
+		for i, s := range body.Levels {
+			level, err := logrus.ParseLevel(s)
+			if err != nil {
+				adminError(w, http.StatusBadRequest, err)
+				return
+			}
+			levels[i] = level
+		}
+		if err := h.Update(Levels(levels...)); err != nil {
+			adminError(w, http.StatusBadRequest, err)
+			return
+		}
+		adminJSON(w, http.StatusOK, h.Status())
+	}))
+	mux.HandleFunc("/sampling", adminPost(func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := adminDecode(req, &body); err != nil {
+			adminError(w, http.StatusBadRequest, err)
+			return
+		}
+		if body.Enabled == nil {
+			adminError(w, http.StatusBadRequest, errors.New("enabled is required"))
+			return
+		}
+		if err := h.Update(SamplingEnabled(*body.Enabled)); err != nil {
+			adminError(w, http.StatusBadRequest, err)
+			return
+		}
+		adminJSON(w, http.StatusOK, h.Status())
+	}))
+	return mux
+}
+
+// adminPost wraps the handler, only allowing POST requests.
+func adminPost(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			adminError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		f(w, req)
+	}
+}
+
+// adminDecode decodes the request's JSON body.
+func adminDecode(req *http.Request, v interface{}) error {
+	if ct := req.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "application/json") {
+		return errors.New("content type must be application/json")
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(nil, req.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// adminJSON writes the value as JSON.
+func adminJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+// adminError writes the error as JSON.
+func adminError(w http.ResponseWriter, code int, err error) {
+	adminJSON(w, code, map[string]string{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,88 @@
+package sdhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		code        int
+		levels      []string
+		sampling    bool
+	}{
+		{"status", "GET", "/", "", "", http.StatusOK, []string{"panic", "fatal", "error", "warning", "info", "debug", "trace"}, true},
+		{"status head", "HEAD", "/", "", "", http.StatusOK, nil, false},
+		{"status post", "POST", "/", "", "", http.StatusMethodNotAllowed, nil, false},
+		{"not found", "GET", "/other", "", "", http.StatusNotFound, nil, false},
+		{"flush", "POST", "/flush", "", "", http.StatusOK, nil, true},
+		{"flush get", "GET", "/flush", "", "", http.StatusMethodNotAllowed, nil, false},
+		{"levels", "POST", "/levels", "application/json", `{"levels":["error","warn"]}`, http.StatusOK, []string{"error", "warning"}, true},
+		{"levels bad level", "POST", "/levels", "application/json", `{"levels":["error","loud"]}`, http.StatusBadRequest, nil, false},
+		{"levels unknown field", "POST", "/levels", "application/json", `{"level":"error"}`, http.StatusBadRequest, nil, false},
+		{"levels content type", "POST", "/levels", "text/plain", `{"levels":["error"]}`, http.StatusBadRequest, nil, false},
+		{"levels get", "GET", "/levels", "", "", http.StatusMethodNotAllowed, nil, false},
+		{"sampling", "POST", "/sampling", "application/json", `{"enabled":false}`, http.StatusOK, nil, false},
+		{"sampling without enabled", "POST", "/sampling", "application/json", `{}`, http.StatusBadRequest, nil, false},
+		{"sampling put", "PUT", "/sampling", "application/json", `{"enabled":true}`, http.StatusMethodNotAllowed, nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newTestHook(t, new(fakeLogging), Sampling(time.Minute, 10, 0))
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+			w := httptest.NewRecorder()
+			h.AdminHandler().ServeHTTP(w, req)
+			if w.Code != test.code {
+				t.Fatalf("expected status %d, got: %d (%s)", test.code, w.Code, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("expected content type application/json, got: %q", ct)
+			}
+			if test.method == "HEAD" {
+				return
+			}
+			if test.code != http.StatusOK {
+				var res struct {
+					Error string `json:"error"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.Error == "" {
+					t.Errorf("expected error response, got: %s", w.Body.String())
+				}
+				if n := len(h.Status().Levels); n != 7 {
+					t.Errorf("expected levels to not be changed, got: %d levels", n)
+				}
+				return
+			}
+			var res struct {
+				ProjectID string   `json:"project_id"`
+				Levels    []string `json:"levels"`
+				Sampling  bool     `json:"sampling"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if res.ProjectID != "project" {
+				t.Errorf("expected project %q, got: %q", "project", res.ProjectID)
+			}
+			if test.levels != nil && !reflect.DeepEqual(res.Levels, test.levels) {
+				t.Errorf("expected levels %v, got: %v", test.levels, res.Levels)
+			}
+			if res.Sampling != test.sampling {
+				t.Errorf("expected sampling %t, got: %t", test.sampling, res.Sampling)
+			}
+		})
+	}
+}
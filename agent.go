@@ -0,0 +1,124 @@
+package sdhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// agentMaxRetry is the number of attempts to deliver a message to the
+// logging agent, kept low so that failures are reported, and failover chains
+// switch sinks, within seconds.
+const agentMaxRetry = 5
+
+// agentPost is a record posted to the logging agent, awaiting the result of
+// its delivery.
+type agentPost struct {
+	r      *record
+	errMsg string
+}
+
+// agentQueue tracks the records posted to the asynchronous logging agent
+// client, so that delivery results can be matched to their records. The
+// client delivers posted messages in order from a single goroutine, so
+// results are reported in the order the records were posted.
+type agentQueue struct {
+	// postMu orders posts, so that the queue matches the client's queue.
+	postMu sync.Mutex
+	mu     sync.Mutex
+	posts  []agentPost
+}
+
+// post posts the message for the record with post, queuing the record until
+// the result of its delivery is reported.
+func (q *agentQueue) post(r *record, errMsg string, post func() error) error {
+	q.postMu.Lock()
+	defer q.postMu.Unlock()
+	q.mu.Lock()
+	q.posts = append(q.posts, agentPost{r, errMsg})
+	q.mu.Unlock()
+	// posting may block while the client's buffer is full, so the queue is
+	// not locked, allowing results to be reported
+	if err := post(); err != nil {
+		// the message was not queued by the client, and no other record was
+		// posted since
+		q.mu.Lock()
+		q.posts = q.posts[:len(q.posts)-1]
+		q.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// pop removes the oldest posted record.
+func (q *agentQueue) pop() (agentPost, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.posts) == 0 {
+		return agentPost{}, false
+	}
+	p := q.posts[0]
+	q.posts[0] = agentPost{}
+	q.posts = q.posts[1:]
+	return p, true
+}
+
+// postAgent posts the record's message to the logging agent. The result of
+// the delivery is reported asynchronously to agentResult.
+func (h *Hook) postAgent(r *record, tag string, message interface{}, errMsg string) {
+	err := h.agentQueue.post(r, errMsg, func() error {
+		return h.agentClient.Post(tag, message)
+	})
+	if err != nil {
+		h.agentFailed(r, errMsg, err)
+	}
+}
+
+// agentResult is the logging agent client's callback for the result of the
+// delivery of the oldest posted message.
+func (h *Hook) agentResult(_ []byte, err error) {
+	p, ok := h.agentQueue.pop()
+	if !ok {
+		return
+	}
+	if err != nil {
+		h.agentFailed(p.r, p.errMsg, err)
+		return
+	}
+	h.stats.written.Add(1)
+	h.delivered(p.r)
+}
+
+// agentFailed passes the record to the next sink of its failover chain, or
+// drops it, after a failed delivery to the logging agent.
+func (h *Hook) agentFailed(r *record, errMsg string, err error) {
+	h.stats.failed.Add(1)
+	if h.failed(r, err) {
+		return
+	}
+	h.handleError(&DeliveryError{
+		Transport: TransportAgent,
+		Entry:     r.entry,
+		Attempt:   1,
+		Dropped:   true,
+		Reason:    DropAgentFailed,
+		Err:       fmt.Errorf("%s: %w", errMsg, err),
+	})
+}
+
+// probe satisfies the hookProber interface, connecting to the logging
+// agent.
+func (agentSink) probe(h *Hook) error {
+	if h.agentClient == nil {
+		return errors.New("no logging agent was provided")
+	}
+	ctx, cancel := h.callContext(h.writeTimeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", h.agentAddr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
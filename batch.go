@@ -0,0 +1,195 @@
+package sdhook
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	logging "google.golang.org/api/logging/v2"
+)
+
+// destination is the destination of a write.
+type destination struct {
+	// logName is the full resource name of the log.
+	logName string
+	// projectID is the project.
+	projectID string
+	// resource is the key of the monitored resource (see resourceKey).
+	resource string
+	// credentials is the credentials key.
+	credentials string
+}
+
+// batcher batches log entries per destination.
+type batcher struct {
+	size     int
+	interval time.Duration
+	write    func(destination, []*logging.LogEntry, []*record)
+
+	mu      sync.Mutex
+	batches map[destination]*batch
+	// wg tracks writes of batches taken from batches.
+	wg sync.WaitGroup
+	// pending tracks writes of batches taken from batches, for flushContext.
+	pending tracker
+}
+
+// batch is a batch of log entries for a destination.
+type batch struct {
+	entries []*logging.LogEntry
+	records []*record
+	timer   *time.Timer
+}
+
+// newBatcher creates a batcher writing batches of up to size log entries,
+// at most interval after the first log entry of the batch was added.
+func newBatcher(size int, interval time.Duration, write func(destination, []*logging.LogEntry, []*record)) *batcher {
+	return &batcher{
+		size:     size,
+		interval: interval,
+		write:    write,
+		batches:  make(map[destination]*batch),
+	}
+}
+
+// add adds the log entries to the destination's batch, where records[i] is
+// the record of entries[i]. The batch is written when it is full.
+func (b *batcher) add(dest destination, entries []*logging.LogEntry, records []*record) {
+	b.mu.Lock()
+	bt, ok := b.batches[dest]
+	if !ok {
+		bt = new(batch)
+		b.batches[dest] = bt
+		bt.timer = time.AfterFunc(b.interval, func() {
+			b.flushDest(dest, bt)
+		})
+	}
+	bt.entries = append(bt.entries, entries...)
+	bt.records = append(bt.records, records...)
+	if len(bt.entries) < b.size {
+		b.mu.Unlock()
+		return
+	}
+	bt.timer.Stop()
+	delete(b.batches, dest)
+	b.wg.Add(1)
+	e := b.pending.add()
+	b.mu.Unlock()
+	defer b.wg.Done()
+	defer b.pending.finish(e)
+	b.write(dest, bt.entries, bt.records)
+}
+
+// flushDest writes the destination's batch, if it is still pending.
+func (b *batcher) flushDest(dest destination, bt *batch) {
+	b.mu.Lock()
+	if b.batches[dest] != bt {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.batches, dest)
+	b.wg.Add(1)
+	e := b.pending.add()
+	b.mu.Unlock()
+	defer b.wg.Done()
+	defer b.pending.finish(e)
+	b.write(dest, bt.entries, bt.records)
+}
+
+// flush writes all pending batches, and waits for all writes to complete.
+func (b *batcher) flush() {
+	b.mu.Lock()
+	batches := b.batches
+	b.batches = make(map[destination]*batch)
+	for _, bt := range batches {
+		bt.timer.Stop()
+	}
+	b.wg.Add(len(batches))
+	b.mu.Unlock()
+	for dest, bt := range batches {
+		go func(dest destination, bt *batch) {
+			defer b.wg.Done()
+			b.write(dest, bt.entries, bt.records)
+		}(dest, bt)
+	}
+	b.wg.Wait()
+}
+
+// flushContext writes all pending batches, and waits for the writes started
+// before the call to complete, or until ctx is done.
+func (b *batcher) flushContext(ctx context.Context) error {
+	b.mu.Lock()
+	batches := b.batches
+	b.batches = make(map[destination]*batch)
+	for dest, bt := range batches {
+		bt.timer.Stop()
+		b.wg.Add(1)
+		e := b.pending.add()
+		go func(dest destination, bt *batch) {
+			defer b.wg.Done()
+			defer b.pending.finish(e)
+			b.write(dest, bt.entries, bt.records)
+		}(dest, bt)
+	}
+	b.mu.Unlock()
+	return b.pending.wait(ctx)
+}
+
+// write writes the log entries to the destination, where records[i] is the
+// record of entries[i].
+func (h *Hook) write(dest destination, entries []*logging.LogEntry, records []*record) {
+	service, _, err := h.services(dest.credentials)
+	if err != nil {
+		h.stats.failed.Add(uint64(len(entries)))
+		h.writeFailed(records, 1, err)
+		return
+	}
+	h.mu.RLock()
+	req := &logging.WriteLogEntriesRequest{
+		LogName: dest.logName,
+		// all records of the destination have the same monitored resource
+		Resource:       records[0].route.Resource,
+		Labels:         h.labels,
+		PartialSuccess: h.partialSuccess,
+		Entries:        entries,
+	}
+	h.mu.RUnlock()
+	attempt, err := h.writeEntries(service, req, records)
+	if err != nil {
+		h.writeFailed(records, attempt, err)
+		return
+	}
+	seen := make(map[*record]bool, len(records))
+	for _, r := range records {
+		if !seen[r] {
+			seen[r] = true
+			h.delivered(r)
+		}
+	}
+}
+
+// writeFailed passes a write error to the error handler for each record, or
+// to the next sink of the record's failover chain.
+func (h *Hook) writeFailed(records []*record, attempt int, err error) {
+	// report each record once, as a record split across multiple log
+	// entries appears multiple times
+	seen := make(map[*record]bool, len(records))
+	for _, r := range records {
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+		if h.failed(r, err) {
+			continue
+		}
+		h.handleError(&DeliveryError{
+			Transport: TransportAPI,
+			Entry:     r.entry,
+			Attempt:   attempt,
+			Dropped:   true,
+			Reason:    circuitReason(err, DropWriteFailed),
+			Err:       fmt.Errorf("cannot deliver log entry: %w", err),
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package sdhook
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	logging "google.golang.org/api/logging/v2"
+)
+
+// batchWrite is a write of a batch.
+type batchWrite struct {
+	dest    destination
+	entries []*logging.LogEntry
+}
+
+// batchRecorder records the writes of a batcher.
+type batchRecorder struct {
+	mu     sync.Mutex
+	writes []batchWrite
+	// block, when set, blocks writes until closed.
+	block chan struct{}
+}
+
+// write records the write.
+func (r *batchRecorder) write(dest destination, entries []*logging.LogEntry, records []*record) {
+	if r.block != nil {
+		<-r.block
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writes = append(r.writes, batchWrite{dest, entries})
+}
+
+// sizes returns the number of log entries of each write, by log name.
+func (r *batchRecorder) sizes() map[string][]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sizes := make(map[string][]int)
+	for _, w := range r.writes {
+		sizes[w.dest.logName] = append(sizes[w.dest.logName], len(w.entries))
+	}
+	return sizes
+}
+
+func TestBatcher(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		interval time.Duration
+		// adds are the log names of the added log entries.
+		adds []string
+		// wait is the time to wait before flushing.
+		wait time.Duration
+		// before are the sizes of the writes before flushing.
+		before map[string][]int
+		// after are the sizes of the writes after flushing.
+		after map[string][]int
+	}{
+		{
+			"pending", 3, time.Hour,
+			[]string{"a", "a"}, 0,
+			map[string][]int{},
+			map[string][]int{"a": {2}},
+		},
+		{
+			"full", 2, time.Hour,
+			[]string{"a", "a", "a", "a", "a"}, 0,
+			map[string][]int{"a": {2, 2}},
+			map[string][]int{"a": {2, 2, 1}},
+		},
+		{
+			"per destination", 2, time.Hour,
+			[]string{"a", "b", "a", "b", "b"}, 0,
+			map[string][]int{"a": {2}, "b": {2}},
+			map[string][]int{"a": {2}, "b": {2, 1}},
+		},
+		{
+			"interval", 10, 10 * time.Millisecond,
+			[]string{"a", "b", "a"}, 200 * time.Millisecond,
+			map[string][]int{"a": {2}, "b": {1}},
+			map[string][]int{"a": {2}, "b": {1}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := new(batchRecorder)
+			b := newBatcher(test.size, test.interval, r.write)
+			for _, logName := range test.adds {
+				b.add(destination{logName: logName}, []*logging.LogEntry{{}}, []*record{nil})
+			}
+			time.Sleep(test.wait)
+			checkSizes(t, "before flush", test.before, r.sizes())
+			b.flush()
+			checkSizes(t, "after flush", test.after, r.sizes())
+		})
+	}
+}
+
+func TestBatcherFlushContext(t *testing.T) {
+	r := &batchRecorder{block: make(chan struct{})}
+	b := newBatcher(10, time.Hour, r.write)
+	b.add(destination{logName: "a"}, []*logging.LogEntry{{}}, []*record{nil})
+	// the write is blocked, so the flush times out
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.flushContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got: %v", context.DeadlineExceeded, err)
+	}
+	close(r.block)
+	// the write started by the previous flush is waited for
+	if err := b.flushContext(context.Background()); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	checkSizes(t, "after flush", map[string][]int{"a": {1}}, r.sizes())
+}
+
+// checkSizes checks the sizes of the writes.
+func checkSizes(t *testing.T, when string, exp, sizes map[string][]int) {
+	t.Helper()
+	if len(sizes) != len(exp) {
+		t.Fatalf("%s: expected writes %v, got: %v", when, exp, sizes)
+	}
+	for logName, v := range exp {
+		if len(sizes[logName]) != len(v) {
+			t.Fatalf("%s: expected writes %v, got: %v", when, exp, sizes)
+		}
+		for i, n := range v {
+			if sizes[logName][i] != n {
+				t.Errorf("%s: expected writes %v, got: %v", when, exp, sizes)
+			}
+		}
+	}
+}
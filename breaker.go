@@ -0,0 +1,144 @@
+package sdhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// ErrCircuitOpen is the error for writes and reports short-circuited by an
+// open circuit breaker.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+// CircuitState values.
+const (
+	// CircuitClosed is the state of a circuit breaker letting calls through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen is the state of a circuit breaker short-circuiting calls
+	// after consecutive failures.
+	CircuitOpen
+	// CircuitHalfOpen is the state of a circuit breaker letting a single
+	// probe call through after the cooldown.
+	CircuitHalfOpen
+)
+
+// String satisfies the fmt.Stringer interface.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// MarshalText satisfies the encoding.TextMarshaler interface.
+func (s CircuitState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// breaker is a circuit breaker.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+}
+
+// newBreaker creates a circuit breaker opening after threshold consecutive
+// failures, and half-opening after the cooldown.
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// allow returns ErrCircuitOpen if the call must be short-circuited. When the
+// cooldown has passed, a single probe call is let through.
+func (b *breaker) allow(now time.Time) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = CircuitHalfOpen
+		return nil
+	case CircuitHalfOpen:
+		// probe in flight
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// done records the result of a call let through by allow.
+func (b *breaker) done(err error, now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !breakerFailure(err) {
+		b.state, b.failures = CircuitClosed, 0
+		return
+	}
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.threshold {
+		b.state, b.openedAt = CircuitOpen, now
+	}
+}
+
+// current returns the state of the circuit breaker.
+func (b *breaker) current() CircuitState {
+	if b == nil {
+		return CircuitClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// circuitReason returns DropCircuitOpen for errors short-circuited by a
+// circuit breaker, and the drop reason otherwise.
+func circuitReason(err error, reason string) string {
+	if errors.Is(err, ErrCircuitOpen) {
+		return DropCircuitOpen
+	}
+	return reason
+}
+
+// breakerFailure returns true if the error counts as a failure of the
+// service. Errors caused by the request, such as invalid log entries, do not
+// count.
+func breakerFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Code < http.StatusInternalServerError {
+		switch gerr.Code {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return true
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package sdhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestBreaker(t *testing.T) {
+	failure := errors.New("failed")
+	type step struct {
+		// after is the time since the start.
+		after time.Duration
+		// allowed is whether the call is expected to be let through.
+		allowed bool
+		// err is the result of the call, when let through.
+		err error
+		// state is the expected state after the step.
+		state CircuitState
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"closed", []step{
+			{0, true, nil, CircuitClosed},
+			{0, true, failure, CircuitClosed},
+			{0, true, nil, CircuitClosed},
+			{0, true, failure, CircuitClosed},
+		}},
+		{"opens", []step{
+			{0, true, failure, CircuitClosed},
+			{0, true, failure, CircuitOpen},
+			{time.Second, false, nil, CircuitOpen},
+		}},
+		{"recovers", []step{
+			{0, true, failure, CircuitClosed},
+			{0, true, failure, CircuitOpen},
+			{time.Minute, true, nil, CircuitClosed},
+			{time.Minute, true, failure, CircuitClosed},
+		}},
+		{"probe fails", []step{
+			{0, true, failure, CircuitClosed},
+			{0, true, failure, CircuitOpen},
+			{time.Minute, true, failure, CircuitOpen},
+			{time.Minute + time.Second, false, nil, CircuitOpen},
+			{2 * time.Minute, true, nil, CircuitClosed},
+		}},
+		{"not failures", []step{
+			{0, true, &googleapi.Error{Code: http.StatusBadRequest}, CircuitClosed},
+			{0, true, context.Canceled, CircuitClosed},
+			{0, true, &googleapi.Error{Code: http.StatusBadRequest}, CircuitClosed},
+		}},
+		{"service failures", []step{
+			{0, true, &googleapi.Error{Code: http.StatusTooManyRequests}, CircuitClosed},
+			{0, true, fmt.Errorf("write: %w", &googleapi.Error{Code: http.StatusServiceUnavailable}), CircuitOpen},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newBreaker(2, time.Minute)
+			start := time.Now()
+			for i, s := range test.steps {
+				now := start.Add(s.after)
+				err := b.allow(now)
+				switch {
+				case s.allowed && err != nil:
+					t.Fatalf("step %d: expected call to be allowed, got: %v", i, err)
+				case !s.allowed && !errors.Is(err, ErrCircuitOpen):
+					t.Fatalf("step %d: expected ErrCircuitOpen, got: %v", i, err)
+				}
+				if s.allowed {
+					if i != 0 && test.steps[i-1].state == CircuitOpen {
+						if state := b.current(); state != CircuitHalfOpen {
+							t.Errorf("step %d: expected state %s while probing, got: %s", i, CircuitHalfOpen, state)
+						}
+						if err := b.allow(now); !errors.Is(err, ErrCircuitOpen) {
+							t.Errorf("step %d: expected a single probe, got: %v", i, err)
+						}
+					}
+					b.done(s.err, now)
+				}
+				if state := b.current(); state != s.state {
+					t.Errorf("step %d: expected state %s, got: %s", i, s.state, state)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerNil(t *testing.T) {
+	var b *breaker
+	if err := b.allow(time.Now()); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+	b.done(errors.New("failed"), time.Now())
+	if state := b.current(); state != CircuitClosed {
+		t.Errorf("expected state %s, got: %s", CircuitClosed, state)
+	}
+}
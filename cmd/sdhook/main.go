@@ -0,0 +1,496 @@
+// Command sdhook writes, reports, tails and verifies Stackdriver log entries
+// using the sdhook package, for debugging a hook's setup.
+//
+// Usage:
+//
+//	sdhook <command> [flags] [args]
+//
+// Run sdhook help for the list of commands.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/kenshaw/jwt/gserviceaccount"
+	"github.com/kenshaw/sdhook"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	logging "google.golang.org/api/logging/v2"
+	"google.golang.org/api/option"
+)
+
+func main() {
+	if err := run(context.Background(), os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// command is a sdhook command.
+type command struct {
+	name  string
+	usage string
+	run   func(context.Context, []string) error
+}
+
+// commands are the sdhook commands.
+var commands = []command{
+	{"write", "write a test log entry", runWrite},
+	{"report", "report a test error event", runReport},
+	{"verify", "verify credentials and permissions", runVerify},
+	{"restypes", "list the monitored resource types and their labels", runResTypes},
+	{"tail", "print the latest log entries of a log", runTail},
+}
+
+// run runs the command.
+func run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return errors.New("no command given")
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			if err := c.run(ctx, args[1:]); !errors.Is(err, flag.ErrHelp) {
+				return err
+			}
+			return nil
+		}
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(os.Stdout)
+		return nil
+	}
+	usage(os.Stderr)
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// usage writes the usage.
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: sdhook <command> [flags] [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.usage)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run sdhook <command> -h for the flags of a command. Flags default to the")
+	fmt.Fprintln(w, "SDHOOK_* environment variables read by sdhook.FromEnv.")
+}
+
+// flags are the flags common to the commands.
+type flags struct {
+	*flag.FlagSet
+	credentials string
+	project     string
+	logName     string
+}
+
+// newFlags creates the flag set for the command, with the common flags.
+func newFlags(name, args string) *flags {
+	f := &flags{
+		FlagSet: flag.NewFlagSet(name, flag.ContinueOnError),
+	}
+	f.StringVar(&f.credentials, "credentials", os.Getenv(sdhook.EnvCredentialsFile), "Google Service Account credentials `file` (default GCE compute credentials)")
+	f.StringVar(&f.project, "project", os.Getenv(sdhook.EnvProjectID), "project `id` (default from the credentials)")
+	f.StringVar(&f.logName, "log", os.Getenv(sdhook.EnvLogName), "log `name` (default \"default\")")
+	f.Usage = func() {
+		fmt.Fprintf(f.Output(), "usage: sdhook %s [flags] %s\n\nflags:\n", name, args)
+		f.PrintDefaults()
+	}
+	return f
+}
+
+// options returns the hook options for the common flags.
+func (f *flags) options() ([]sdhook.Option, error) {
+	var opts []sdhook.Option
+	project := f.project
+	if f.credentials != "" {
+		opts = append(opts, sdhook.GoogleServiceAccountCredentialsFile(f.credentials))
+	} else {
+		var err error
+		if project, err = f.computeProject(); err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdhook.GoogleComputeCredentials(""))
+	}
+	if project != "" {
+		opts = append(opts,
+			sdhook.ProjectID(project),
+			sdhook.Resource(sdhook.ResTypeProject, map[string]string{
+				"project_id": project,
+			}),
+		)
+	}
+	if f.logName != "" {
+		opts = append(opts, sdhook.LogName(f.logName))
+	}
+	return opts, nil
+}
+
+// hook creates a hook for the common flags and the options, collecting
+// delivery errors.
+func (f *flags) hook(opts ...sdhook.Option) (*sdhook.Hook, *deliveryErrors, error) {
+	o, err := f.options()
+	if err != nil {
+		return nil, nil, err
+	}
+	errs := new(deliveryErrors)
+	h, err := sdhook.New(append(append(o, sdhook.ErrorHandler(errs.add)), opts...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, errs, nil
+}
+
+// computeProject returns the project for the GCE compute credentials,
+// defaulting to the project of the GCE compute instance.
+func (f *flags) computeProject() (string, error) {
+	if !metadata.OnGCE() {
+		return "", errors.New("not running on GCE: -credentials must be provided")
+	}
+	if f.project != "" {
+		return f.project, nil
+	}
+	return metadata.ProjectID()
+}
+
+// service creates the logging service for the common flags, with the same
+// credentials as the hook, returning the service and project id.
+func (f *flags) service(ctx context.Context) (*logging.Service, string, error) {
+	project := f.project
+	var ts oauth2.TokenSource
+	if f.credentials != "" {
+		buf, err := os.ReadFile(f.credentials)
+		if err != nil {
+			return nil, "", err
+		}
+		gsa, err := gserviceaccount.FromJSON(buf)
+		if err != nil {
+			return nil, "", err
+		}
+		if project == "" {
+			project = gsa.ProjectID
+		}
+		if ts, err = gsa.TokenSource(ctx, logging.CloudPlatformScope); err != nil {
+			return nil, "", err
+		}
+	} else {
+		var err error
+		if project, err = f.computeProject(); err != nil {
+			return nil, "", err
+		}
+		ts = google.ComputeTokenSource("")
+	}
+	service, err := logging.NewService(ctx, option.WithHTTPClient(oauth2.NewClient(ctx, ts)))
+	if err != nil {
+		return nil, "", err
+	}
+	return service, project, nil
+}
+
+// deliveryErrors collects the delivery errors of a hook.
+type deliveryErrors struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+// add adds the delivery error.
+func (d *deliveryErrors) add(err *sdhook.DeliveryError) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.errs = append(d.errs, err)
+}
+
+// close closes the hook, returning the collected delivery errors.
+func (d *deliveryErrors) close(h *sdhook.Hook) error {
+	if err := h.Close(); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return errors.Join(d.errs...)
+}
+
+// labelsFlag is a flag of comma separated key=value pairs.
+type labelsFlag map[string]string
+
+// String satisfies the flag.Value interface.
+func (l labelsFlag) String() string {
+	var s []string
+	for k, v := range l {
+		s = append(s, k+"="+v)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+// Set satisfies the flag.Value interface.
+func (l labelsFlag) Set(s string) error {
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if k = strings.TrimSpace(k); !ok || k == "" {
+			return fmt.Errorf("label %q is not a key=value pair", kv)
+		}
+		l[k] = strings.TrimSpace(v)
+	}
+	return nil
+}
+
+// message returns the message from the args, or def.
+func message(args []string, def string) string {
+	if len(args) == 0 {
+		return def
+	}
+	return strings.Join(args, " ")
+}
+
+// runWrite writes a test log entry.
+func runWrite(ctx context.Context, args []string) error {
+	f := newFlags("write", "[message]")
+	severity := f.String("severity", "info", "logrus `level` of the entry, such as debug, info, warning or error")
+	labels := make(labelsFlag)
+	f.Var(labels, "labels", "common `labels` of the entry, such as env=dev,team=core")
+	resource := f.String("resource", "", "monitored resource `type` (default project, see sdhook restypes)")
+	resourceLabels := make(labelsFlag)
+	f.Var(resourceLabels, "resource-labels", "monitored resource `labels`, such as project_id=my-project")
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	level, err := logrus.ParseLevel(*severity)
+	if err != nil {
+		return err
+	}
+	if level == logrus.PanicLevel {
+		return errors.New("-severity panic is not supported")
+	}
+	var opts []sdhook.Option
+	if len(labels) != 0 {
+		opts = append(opts, sdhook.Labels(labels))
+	}
+	if *resource != "" {
+		opts = append(opts, sdhook.Resource(sdhook.ResType(*resource), resourceLabels))
+	}
+	h, errs, err := f.hook(opts...)
+	if err != nil {
+		return err
+	}
+	logger := logrus.New()
+	logger.Out = io.Discard
+	logger.Hooks.Add(h)
+	logger.Log(level, message(f.Args(), "sdhook test entry"))
+	if err := errs.close(h); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s entry to %s\n", level, h.Status().LogName)
+	return nil
+}
+
+// runReport reports a test error event.
+func runReport(ctx context.Context, args []string) error {
+	f := newFlags("report", "[message]")
+	service := f.String("service", envOr(sdhook.EnvErrorService, "sdhook"), "error reporting service `name`")
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	h, errs, err := f.hook(sdhook.ErrorReportingService(*service))
+	if err != nil {
+		return err
+	}
+	logger := logrus.New()
+	logger.Out = io.Discard
+	logger.Hooks.Add(h)
+	logger.Error(message(f.Args(), "sdhook test error"))
+	if err := errs.close(h); err != nil {
+		return err
+	}
+	fmt.Printf("reported error event for service %s to project %s\n", *service, h.Status().ProjectID)
+	return nil
+}
+
+// roles are the predefined roles granting the permissions required by the
+// hook.
+var roles = map[string]string{
+	sdhook.PermissionWriteEntries: "roles/logging.logWriter",
+	sdhook.PermissionReportErrors: "roles/errorreporting.writer",
+}
+
+// runVerify verifies the credentials and permissions.
+func runVerify(ctx context.Context, args []string) error {
+	f := newFlags("verify", "")
+	service := f.String("service", os.Getenv(sdhook.EnvErrorService), "error reporting service `name` (error reporting is not verified when empty)")
+	timeout := f.Duration("timeout", 30*time.Second, "verify `timeout`")
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	var opts []sdhook.Option
+	if *service != "" {
+		opts = append(opts, sdhook.ErrorReportingService(*service))
+	}
+	h, _, err := f.hook(opts...)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	err = h.Verify(ctx)
+	if err == nil {
+		fmt.Printf("ok: can write to %s\n", h.Status().LogName)
+		return nil
+	}
+	errs := []error{err}
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = j.Unwrap()
+	}
+	for _, err := range errs {
+		fmt.Println(err)
+		var derr *sdhook.ServiceDisabledError
+		if errors.As(err, &derr) {
+			fmt.Printf("  enable %s in project %s\n", derr.Service, derr.ProjectID)
+			continue
+		}
+		var perr *sdhook.PermissionError
+		if errors.As(err, &perr) && roles[perr.Permission] != "" {
+			fmt.Printf("  grant %s (or a role including %s) to the service account on project %s\n", roles[perr.Permission], perr.Permission, perr.ProjectID)
+		}
+	}
+	return fmt.Errorf("verify failed with %d error(s)", len(errs))
+}
+
+// runResTypes lists the monitored resource types, with the labels of their
+// descriptors.
+func runResTypes(ctx context.Context, args []string) error {
+	f := newFlags("restypes", "")
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	// the labels are only listed when the descriptors can be fetched
+	labels := make(map[string][]string)
+	service, _, err := f.service(ctx)
+	if err == nil {
+		err = service.MonitoredResourceDescriptors.List().Pages(ctx, func(res *logging.ListMonitoredResourceDescriptorsResponse) error {
+			for _, d := range res.ResourceDescriptors {
+				for _, l := range d.Labels {
+					labels[d.Type] = append(labels[d.Type], l.Key)
+				}
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: cannot list monitored resource descriptors: %v\n", err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tLABELS")
+	for _, typ := range sdhook.ResTypes() {
+		fmt.Fprintf(tw, "%s\t%s\n", typ, strings.Join(labels[string(typ)], ","))
+	}
+	return tw.Flush()
+}
+
+// runTail prints the latest log entries of a log.
+func runTail(ctx context.Context, args []string) error {
+	f := newFlags("tail", "")
+	n := f.Int64("n", 20, "`number` of entries to print")
+	follow := f.Bool("f", false, "follow the log, printing new entries")
+	interval := f.Duration("interval", 5*time.Second, "follow polling `interval`")
+	filter := f.String("filter", "", "additional logging `filter`, such as severity>=ERROR")
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	service, project, err := f.service(ctx)
+	if err != nil {
+		return err
+	}
+	if project == "" {
+		return errors.New("-project must be provided")
+	}
+	logName := f.logName
+	if logName == "" {
+		logName = "default"
+	}
+	if !strings.HasPrefix(logName, "projects/") {
+		logName = "projects/" + project + "/logs/" + url.PathEscape(logName)
+	}
+	base := fmt.Sprintf("logName=%q", logName)
+	if *filter != "" {
+		base += " AND (" + *filter + ")"
+	}
+	// latest entries
+	res, err := service.Entries.List(&logging.ListLogEntriesRequest{
+		ResourceNames: []string{"projects/" + project},
+		Filter:        base,
+		OrderBy:       "timestamp desc",
+		PageSize:      *n,
+	}).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	var last string
+	for i := len(res.Entries) - 1; i >= 0; i-- {
+		printEntry(res.Entries[i])
+		last = res.Entries[i].Timestamp
+	}
+	if !*follow {
+		return nil
+	}
+	if last == "" {
+		last = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(*interval):
+		}
+		err := service.Entries.List(&logging.ListLogEntriesRequest{
+			ResourceNames: []string{"projects/" + project},
+			Filter:        fmt.Sprintf("%s AND timestamp>%q", base, last),
+			OrderBy:       "timestamp asc",
+		}).Pages(ctx, func(res *logging.ListLogEntriesResponse) error {
+			for _, e := range res.Entries {
+				printEntry(e)
+				last = e.Timestamp
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// printEntry prints the log entry.
+func printEntry(e *logging.LogEntry) {
+	payload := e.TextPayload
+	switch {
+	case e.JsonPayload != nil:
+		payload = string(e.JsonPayload)
+	case e.ProtoPayload != nil:
+		payload = string(e.ProtoPayload)
+	}
+	fmt.Printf("%s %-8s %s\n", e.Timestamp, e.Severity, payload)
+}
+
+// envOr returns the environment variable, or def when not set.
+func envOr(name, def string) string {
+	if s := os.Getenv(name); s != "" {
+		return s
+	}
+	return def
+}
@@ -0,0 +1,651 @@
+package sdhook
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logging "google.golang.org/api/logging/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is a declarative hook configuration, that can be read from a YAML
+// or JSON file. Use Config.Options to convert it to options.
+type Config struct {
+	// Levels are the levels, such as "info" (see Levels).
+	Levels []string `json:"levels,omitempty" yaml:"levels,omitempty"`
+	// ProjectID is the project ID (see ProjectID).
+	ProjectID string `json:"project_id,omitempty" yaml:"project_id,omitempty"`
+	// CredentialsFile is the Google Service Account credentials file (see
+	// GoogleServiceAccountCredentialsFile).
+	CredentialsFile string `json:"credentials_file,omitempty" yaml:"credentials_file,omitempty"`
+	// ComputeCredentials are the GCE compute instance credentials (see
+	// GoogleComputeCredentials), which cannot be used with CredentialsFile.
+	ComputeCredentials *ComputeCredentialsConfig `json:"compute_credentials,omitempty" yaml:"compute_credentials,omitempty"`
+	// Agent are the logging agent settings (see GoogleLoggingAgent). With
+	// credentials, Sinks must be set, as log entries are otherwise only
+	// delivered to the logging agent.
+	Agent *AgentConfig `json:"agent,omitempty" yaml:"agent,omitempty"`
+	// Resource is the monitored resource (see Resource).
+	Resource *ResourceConfig `json:"resource,omitempty" yaml:"resource,omitempty"`
+	// LogName is the log name (see LogName).
+	LogName string `json:"log_name,omitempty" yaml:"log_name,omitempty"`
+	// ErrorReportingLogName is the error reporting log name (see
+	// ErrorReportingLogName).
+	ErrorReportingLogName string `json:"error_reporting_log_name,omitempty" yaml:"error_reporting_log_name,omitempty"`
+	// LogNameField is the log name field (see LogNameField).
+	LogNameField string `json:"log_name_field,omitempty" yaml:"log_name_field,omitempty"`
+	// LogNameRules are the log name routing rules (see LogNameRules).
+	LogNameRules []LogNameRuleConfig `json:"log_name_rules,omitempty" yaml:"log_name_rules,omitempty"`
+	// Labels are the common labels (see Labels).
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// PartialSuccess enables partial success (see PartialSuccess).
+	PartialSuccess bool `json:"partial_success,omitempty" yaml:"partial_success,omitempty"`
+	// PartialRetries is the number of partial retries (see PartialRetries).
+	PartialRetries *int `json:"partial_retries,omitempty" yaml:"partial_retries,omitempty"`
+	// ErrorReportingService is the error reporting service name (see
+	// ErrorReportingService).
+	ErrorReportingService string `json:"error_reporting_service,omitempty" yaml:"error_reporting_service,omitempty"`
+	// UseLoggingServiceForErrors reports errors via the logging API (see
+	// UseLoggingServiceForErrors).
+	UseLoggingServiceForErrors bool `json:"use_logging_service_for_errors,omitempty" yaml:"use_logging_service_for_errors,omitempty"`
+	// ErrorDeduplication is the error deduplication (see
+	// ErrorDeduplication).
+	ErrorDeduplication *ErrorDeduplicationConfig `json:"error_deduplication,omitempty" yaml:"error_deduplication,omitempty"`
+	// LabelLimits are the label limits (see LabelLimits).
+	LabelLimits *LabelLimitsConfig `json:"label_limits,omitempty" yaml:"label_limits,omitempty"`
+	// MaxEntrySize is the maximum entry size (see MaxEntrySize).
+	MaxEntrySize *MaxEntrySizeConfig `json:"max_entry_size,omitempty" yaml:"max_entry_size,omitempty"`
+	// TrustedProxies are the trusted proxies (see TrustedProxies).
+	TrustedProxies []string `json:"trusted_proxies,omitempty" yaml:"trusted_proxies,omitempty"`
+	// Redact are the redaction rules (see Redact).
+	Redact []RedactRuleConfig `json:"redact,omitempty" yaml:"redact,omitempty"`
+	// Sampling is the sampling (see Sampling).
+	Sampling *SamplingConfig `json:"sampling,omitempty" yaml:"sampling,omitempty"`
+	// RateLimits are the rate limits (see RateLimit).
+	RateLimits []RateLimitConfig `json:"rate_limits,omitempty" yaml:"rate_limits,omitempty"`
+	// Routes are the field routing rules (see Routes and FieldRouteRule).
+	Routes []FieldRouteConfig `json:"routes,omitempty" yaml:"routes,omitempty"`
+	// RouteCredentialsFiles are the credentials files per credentials key
+	// (see RouteCredentials and CredentialsFiles).
+	RouteCredentialsFiles map[string]string `json:"route_credentials_files,omitempty" yaml:"route_credentials_files,omitempty"`
+	// Batching is the batching (see Batching).
+	Batching *BatchingConfig `json:"batching,omitempty" yaml:"batching,omitempty"`
+	// Sinks are the sinks (see AddSink).
+	Sinks []SinkConfig `json:"sinks,omitempty" yaml:"sinks,omitempty"`
+	// CircuitBreaker is the circuit breaker (see CircuitBreaker).
+	CircuitBreaker *CircuitBreakerConfig `json:"circuit_breaker,omitempty" yaml:"circuit_breaker,omitempty"`
+	// WriteTimeout is the write timeout (see WriteTimeout).
+	WriteTimeout Duration `json:"write_timeout,omitempty" yaml:"write_timeout,omitempty"`
+	// ReportTimeout is the report timeout (see ReportTimeout).
+	ReportTimeout Duration `json:"report_timeout,omitempty" yaml:"report_timeout,omitempty"`
+	// ExpvarStats is the name of the expvar variable publishing the
+	// delivery statistics (see ExpvarStats).
+	ExpvarStats string `json:"expvar_stats,omitempty" yaml:"expvar_stats,omitempty"`
+}
+
+// ComputeCredentialsConfig is the configuration of GCE compute instance
+// credentials.
+type ComputeCredentialsConfig struct {
+	// ServiceAccount is the service account, empty for the default service
+	// account.
+	ServiceAccount string `json:"service_account,omitempty" yaml:"service_account,omitempty"`
+}
+
+// AgentConfig is the configuration of the logging agent.
+type AgentConfig struct {
+	// Host is the agent's host, defaulting to 127.0.0.1.
+	Host string `json:"host,omitempty" yaml:"host,omitempty"`
+	// Port is the agent's port, defaulting to 24224.
+	Port int `json:"port,omitempty" yaml:"port,omitempty"`
+}
+
+// ResourceConfig is the configuration of a monitored resource.
+type ResourceConfig struct {
+	// Type is the resource type.
+	Type string `json:"type" yaml:"type"`
+	// Labels are the resource labels.
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+// LogNameRuleConfig is the configuration of a log name routing rule, routing
+// log entries at the levels, or with the field's value, to the named log.
+type LogNameRuleConfig struct {
+	// Name is the log name.
+	Name string `json:"name" yaml:"name"`
+	// Levels are the levels of a level rule.
+	Levels []string `json:"levels,omitempty" yaml:"levels,omitempty"`
+	// Field is the field of a field rule.
+	Field string `json:"field,omitempty" yaml:"field,omitempty"`
+	// Value is the field value of a field rule.
+	Value string `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+// ErrorDeduplicationConfig is the configuration of error deduplication.
+type ErrorDeduplicationConfig struct {
+	// Window is the deduplication window.
+	Window Duration `json:"window" yaml:"window"`
+	// Size is the number of tracked errors.
+	Size int `json:"size,omitempty" yaml:"size,omitempty"`
+}
+
+// LabelLimitsConfig is the configuration of label limits.
+type LabelLimitsConfig struct {
+	// MaxLabels is the maximum number of labels.
+	MaxLabels int `json:"max_labels" yaml:"max_labels"`
+	// MaxKeySize is the maximum label key size.
+	MaxKeySize int `json:"max_key_size" yaml:"max_key_size"`
+	// MaxValueSize is the maximum label value size.
+	MaxValueSize int `json:"max_value_size" yaml:"max_value_size"`
+}
+
+// MaxEntrySizeConfig is the configuration of the maximum entry size.
+type MaxEntrySizeConfig struct {
+	// Size is the maximum entry size.
+	Size int `json:"size" yaml:"size"`
+	// Mode is the oversize mode, truncate (the default) or split.
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty"`
+}
+
+// RedactRuleConfig is the configuration of a redaction rule (see
+// RedactRule).
+type RedactRuleConfig struct {
+	// Field is the field name.
+	Field string `json:"field,omitempty" yaml:"field,omitempty"`
+	// Glob is the field name pattern.
+	Glob string `json:"glob,omitempty" yaml:"glob,omitempty"`
+	// Value is the value regular expression.
+	Value string `json:"value,omitempty" yaml:"value,omitempty"`
+	// QueryParam is the query parameter name.
+	QueryParam string `json:"query_param,omitempty" yaml:"query_param,omitempty"`
+	// Action is the action, mask (the default), hash or drop.
+	Action string `json:"action,omitempty" yaml:"action,omitempty"`
+}
+
+// SamplingConfig is the configuration of sampling.
+type SamplingConfig struct {
+	// Interval is the sampling interval.
+	Interval Duration `json:"interval" yaml:"interval"`
+	// First is the number of log entries sent per interval.
+	First int `json:"first" yaml:"first"`
+	// Thereafter is the sampling rate after the first log entries.
+	Thereafter int `json:"thereafter,omitempty" yaml:"thereafter,omitempty"`
+}
+
+// RateLimitConfig is the configuration of a rate limit.
+type RateLimitConfig struct {
+	// Rate is the rate of log entries per second.
+	Rate float64 `json:"rate" yaml:"rate"`
+	// Burst is the burst size.
+	Burst int `json:"burst" yaml:"burst"`
+	// Levels are the levels, empty for all levels.
+	Levels []string `json:"levels,omitempty" yaml:"levels,omitempty"`
+}
+
+// FieldRouteConfig is the configuration of a field routing rule.
+type FieldRouteConfig struct {
+	// Field is the field.
+	Field string `json:"field" yaml:"field"`
+	// Routes are the routes per field value.
+	Routes map[string]RouteConfig `json:"routes" yaml:"routes"`
+}
+
+// RouteConfig is the configuration of a route (see Route).
+type RouteConfig struct {
+	// ProjectID is the project ID.
+	ProjectID string `json:"project_id,omitempty" yaml:"project_id,omitempty"`
+	// Resource is the monitored resource.
+	Resource *ResourceConfig `json:"resource,omitempty" yaml:"resource,omitempty"`
+	// Credentials is the credentials key.
+	Credentials string `json:"credentials,omitempty" yaml:"credentials,omitempty"`
+}
+
+// BatchingConfig is the configuration of batching.
+type BatchingConfig struct {
+	// Size is the batch size.
+	Size int `json:"size" yaml:"size"`
+	// Interval is the batch interval.
+	Interval Duration `json:"interval" yaml:"interval"`
+}
+
+// SinkConfig is the configuration of a sink.
+type SinkConfig struct {
+	// Type is the sink type, one of api, agent, stdout, file or failover.
+	Type string `json:"type" yaml:"type"`
+	// Levels are the levels the sink receives, empty for all levels.
+	Levels []string `json:"levels,omitempty" yaml:"levels,omitempty"`
+	// Path is the path of a file sink.
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// MaxSize is the rotation size of a file sink.
+	MaxSize int64 `json:"max_size,omitempty" yaml:"max_size,omitempty"`
+	// MaxBackups is the number of rotated files kept by a file sink.
+	MaxBackups int `json:"max_backups,omitempty" yaml:"max_backups,omitempty"`
+	// Retry is the retry interval of a failover sink.
+	Retry Duration `json:"retry,omitempty" yaml:"retry,omitempty"`
+	// Sinks are the sinks of a failover sink. Their levels are ignored.
+	Sinks []SinkConfig `json:"sinks,omitempty" yaml:"sinks,omitempty"`
+}
+
+// CircuitBreakerConfig is the configuration of circuit breakers.
+type CircuitBreakerConfig struct {
+	// Threshold is the number of consecutive failures opening the circuit
+	// breaker.
+	Threshold int `json:"threshold" yaml:"threshold"`
+	// Cooldown is the time before probing again.
+	Cooldown Duration `json:"cooldown" yaml:"cooldown"`
+}
+
+// Duration is a duration read from a string such as "1m30s", or a number of
+// seconds.
+type Duration time.Duration
+
+// UnmarshalText satisfies the encoding.TextUnmarshaler interface.
+func (d *Duration) UnmarshalText(buf []byte) error {
+	if f, err := strconv.ParseFloat(string(buf), 64); err == nil {
+		*d = Duration(f * float64(time.Second))
+		return nil
+	}
+	v, err := time.ParseDuration(string(buf))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", buf)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// UnmarshalJSON satisfies the json.Unmarshaler interface.
+func (d *Duration) UnmarshalJSON(buf []byte) error {
+	if s, err := strconv.Unquote(string(buf)); err == nil {
+		buf = []byte(s)
+	}
+	return d.UnmarshalText(buf)
+}
+
+// UnmarshalYAML satisfies the yaml.Unmarshaler interface.
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	return d.UnmarshalText([]byte(node.Value))
+}
+
+// MarshalText satisfies the encoding.TextMarshaler interface.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// ConfigError is a configuration error.
+type ConfigError struct {
+	// Key is the path of the bad key, such as "sinks[1].type".
+	Key string
+	// Err is the underlying error.
+	Err error
+}
+
+// Error satisfies the error interface.
+func (err *ConfigError) Error() string {
+	return "config: " + err.Key + ": " + err.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (err *ConfigError) Unwrap() error {
+	return err.Err
+}
+
+// configErr returns a configuration error for the key.
+func configErr(key string, format string, v ...interface{}) error {
+	return &ConfigError{
+		Key: key,
+		Err: fmt.Errorf(format, v...),
+	}
+}
+
+// ParseConfig parses a YAML or JSON configuration. Unknown keys are errors.
+func ParseConfig(buf []byte) (*Config, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	cfg := new(Config)
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	return cfg, nil
+}
+
+// LoadConfig reads a YAML or JSON configuration file.
+func LoadConfig(path string) (*Config, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(buf)
+}
+
+// ConfigFile is an option that configures the hook from a YAML or JSON
+// configuration file (see Config).
+func ConfigFile(path string) Option {
+	return func(h *Hook) error {
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			return err
+		}
+		opts, err := cfg.Options()
+		if err != nil {
+			return err
+		}
+		for _, o := range opts {
+			if err := o(h); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Options validates the configuration, and converts it to options. Errors
+// are returned as a *ConfigError naming the bad key.
+func (cfg *Config) Options() ([]Option, error) {
+	var opts []Option
+	add := func(key string, o Option) {
+		opts = append(opts, keyed(key, o))
+	}
+	// credentials and transport first, as they set the project and resource
+	if cfg.CredentialsFile != "" && cfg.ComputeCredentials != nil {
+		return nil, configErr("compute_credentials", "cannot be used with credentials_file")
+	}
+	if cfg.Agent != nil && (cfg.CredentialsFile != "" || cfg.ComputeCredentials != nil) && len(cfg.Sinks) == 0 {
+		// without sinks, log entries are only delivered to the agent
+		key := "credentials_file"
+		if cfg.ComputeCredentials != nil {
+			key = "compute_credentials"
+		}
+		return nil, configErr("agent", "cannot be used with %s without sinks", key)
+	}
+	if cfg.CredentialsFile != "" {
+		add("credentials_file", GoogleServiceAccountCredentialsFile(cfg.CredentialsFile))
+	}
+	if cfg.ComputeCredentials != nil {
+		add("compute_credentials", GoogleComputeCredentials(cfg.ComputeCredentials.ServiceAccount))
+	}
+	if cfg.Agent != nil {
+		add("agent", googleLoggingAgent(cfg.Agent.Host, cfg.Agent.Port))
+	}
+	if cfg.ProjectID != "" {
+		add("project_id", ProjectID(cfg.ProjectID))
+	}
+	if cfg.Resource != nil {
+		res, err := cfg.Resource.resource("resource")
+		if err != nil {
+			return nil, err
+		}
+		add("resource", MonitoredResource(res))
+	}
+	// log names, after the project
+	if cfg.LogName != "" {
+		add("log_name", LogName(cfg.LogName))
+	}
+	if cfg.ErrorReportingLogName != "" {
+		add("error_reporting_log_name", ErrorReportingLogName(cfg.ErrorReportingLogName))
+	}
+	if cfg.LogNameField != "" {
+		add("log_name_field", LogNameField(cfg.LogNameField))
+	}
+	for i, rule := range cfg.LogNameRules {
+		key := fmt.Sprintf("log_name_rules[%d]", i)
+		switch {
+		case rule.Name == "":
+			return nil, configErr(key+".name", "log name is required")
+		case len(rule.Levels) != 0 && rule.Field != "":
+			return nil, configErr(key, "only one of levels or field may be set")
+		case len(rule.Levels) != 0:
+			levels, err := parseLevels(key+".levels", rule.Levels)
+			if err != nil {
+				return nil, err
+			}
+			add(key, LogNameRules(LevelLogNameRule(rule.Name, levels...)))
+		case rule.Field != "":
+			add(key, LogNameRules(FieldLogNameRule(rule.Name, rule.Field, rule.Value)))
+		default:
+			return nil, configErr(key, "levels or field is required")
+		}
+	}
+	if len(cfg.Levels) != 0 {
+		levels, err := parseLevels("levels", cfg.Levels)
+		if err != nil {
+			return nil, err
+		}
+		add("levels", Levels(levels...))
+	}
+	if cfg.Labels != nil {
+		add("labels", Labels(cfg.Labels))
+	}
+	if cfg.PartialSuccess {
+		add("partial_success", PartialSuccess(true))
+	}
+	if cfg.PartialRetries != nil {
+		add("partial_retries", PartialRetries(*cfg.PartialRetries))
+	}
+	if cfg.ErrorReportingService != "" {
+		add("error_reporting_service", ErrorReportingService(cfg.ErrorReportingService))
+	}
+	if cfg.UseLoggingServiceForErrors {
+		add("use_logging_service_for_errors", UseLoggingServiceForErrors(true))
+	}
+	if d := cfg.ErrorDeduplication; d != nil {
+		add("error_deduplication", ErrorDeduplication(time.Duration(d.Window), d.Size))
+	}
+	if l := cfg.LabelLimits; l != nil {
+		add("label_limits", LabelLimits(l.MaxLabels, l.MaxKeySize, l.MaxValueSize))
+	}
+	if m := cfg.MaxEntrySize; m != nil {
+		var mode OversizeMode
+		switch m.Mode {
+		case "", "truncate":
+			mode = OversizeTruncate
+		case "split":
+			mode = OversizeSplit
+		default:
+			return nil, configErr("max_entry_size.mode", "invalid mode %q: must be truncate or split", m.Mode)
+		}
+		add("max_entry_size", MaxEntrySize(m.Size, mode))
+	}
+	if len(cfg.TrustedProxies) != 0 {
+		add("trusted_proxies", TrustedProxies(cfg.TrustedProxies...))
+	}
+	for i, r := range cfg.Redact {
+		key := fmt.Sprintf("redact[%d]", i)
+		rule, err := r.rule(key)
+		if err != nil {
+			return nil, err
+		}
+		add(key, Redact(rule))
+	}
+	if s := cfg.Sampling; s != nil {
+		add("sampling", Sampling(time.Duration(s.Interval), s.First, s.Thereafter))
+	}
+	for i, r := range cfg.RateLimits {
+		key := fmt.Sprintf("rate_limits[%d]", i)
+		levels, err := parseLevels(key+".levels", r.Levels)
+		if err != nil {
+			return nil, err
+		}
+		add(key, RateLimit(r.Rate, r.Burst, levels...))
+	}
+	for i, r := range cfg.Routes {
+		key := fmt.Sprintf("routes[%d]", i)
+		if r.Field == "" {
+			return nil, configErr(key+".field", "field is required")
+		}
+		routes := make(map[string]Route, len(r.Routes))
+		for value, rt := range r.Routes {
+			route := Route{
+				ProjectID:   rt.ProjectID,
+				Credentials: rt.Credentials,
+			}
+			if rt.Resource != nil {
+				var err error
+				if route.Resource, err = rt.Resource.resource(key + ".routes." + value + ".resource"); err != nil {
+					return nil, err
+				}
+			}
+			if rt.Credentials != "" {
+				if _, ok := cfg.RouteCredentialsFiles[rt.Credentials]; !ok {
+					return nil, configErr(key+".routes."+value+".credentials", "no route credentials file for %q", rt.Credentials)
+				}
+			}
+			routes[value] = route
+		}
+		add(key, Routes(FieldRouteRule(r.Field, routes)))
+	}
+	if len(cfg.RouteCredentialsFiles) != 0 {
+		add("route_credentials_files", RouteCredentials(CredentialsFiles(cfg.RouteCredentialsFiles)))
+	}
+	if b := cfg.Batching; b != nil {
+		add("batching", Batching(b.Size, time.Duration(b.Interval)))
+	}
+	for i, s := range cfg.Sinks {
+		key := fmt.Sprintf("sinks[%d]", i)
+		levels, err := parseLevels(key+".levels", s.Levels)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.validate(key); err != nil {
+			return nil, err
+		}
+		s := s
+		add(key, func(h *Hook) error {
+			sink, err := s.sink()
+			if err != nil {
+				return err
+			}
+			return AddSink(sink, levels...)(h)
+		})
+	}
+	if c := cfg.CircuitBreaker; c != nil {
+		add("circuit_breaker", CircuitBreaker(c.Threshold, time.Duration(c.Cooldown)))
+	}
+	if cfg.WriteTimeout != 0 {
+		add("write_timeout", WriteTimeout(time.Duration(cfg.WriteTimeout)))
+	}
+	if cfg.ReportTimeout != 0 {
+		add("report_timeout", ReportTimeout(time.Duration(cfg.ReportTimeout)))
+	}
+	if cfg.ExpvarStats != "" {
+		add("expvar_stats", ExpvarStats(cfg.ExpvarStats))
+	}
+	return opts, nil
+}
+
+// keyed wraps the option's errors as configuration errors for the key.
+func keyed(key string, o Option) Option {
+	return func(h *Hook) error {
+		if err := o(h); err != nil {
+			return &ConfigError{
+				Key: key,
+				Err: err,
+			}
+		}
+		return nil
+	}
+}
+
+// parseLevels parses the level names.
+func parseLevels(key string, names []string) ([]logrus.Level, error) {
+	levels := make([]logrus.Level, len(names))
+	for i, name := range names {
+		level, err := logrus.ParseLevel(name)
+		if err != nil {
+			return nil, configErr(fmt.Sprintf("%s[%d]", key, i), "invalid level %q", name)
+		}
+		levels[i] = level
+	}
+	return levels, nil
+}
+
+// resource returns the monitored resource.
+func (cfg *ResourceConfig) resource(key string) (*logging.MonitoredResource, error) {
+	if cfg.Type == "" {
+		return nil, configErr(key+".type", "resource type is required")
+	}
+	return &logging.MonitoredResource{
+		Type:   cfg.Type,
+		Labels: cfg.Labels,
+	}, nil
+}
+
+// rule returns the redaction rule.
+func (cfg RedactRuleConfig) rule(key string) (RedactRule, error) {
+	rule := RedactRule{
+		Field:      cfg.Field,
+		Glob:       cfg.Glob,
+		QueryParam: cfg.QueryParam,
+	}
+	if cfg.Value != "" {
+		re, err := regexp.Compile(cfg.Value)
+		if err != nil {
+			return rule, configErr(key+".value", "invalid regular expression: %v", err)
+		}
+		rule.Value = re
+	}
+	switch cfg.Action {
+	case "", "mask":
+		rule.Action = RedactMask
+	case "hash":
+		rule.Action = RedactHash
+	case "drop":
+		rule.Action = RedactDrop
+	default:
+		return rule, configErr(key+".action", "invalid action %q: must be mask, hash or drop", cfg.Action)
+	}
+	if err := rule.validate(); err != nil {
+		return rule, configErr(key, "%v", err)
+	}
+	return rule, nil
+}
+
+// validate validates the sink configuration.
+func (cfg SinkConfig) validate(key string) error {
+	switch cfg.Type {
+	case "api", "agent", "stdout":
+	case "file":
+		if cfg.Path == "" {
+			return configErr(key+".path", "path is required")
+		}
+	case "failover":
+		if len(cfg.Sinks) == 0 {
+			return configErr(key+".sinks", "sinks are required")
+		}
+		for i, s := range cfg.Sinks {
+			if err := s.validate(fmt.Sprintf("%s.sinks[%d]", key, i)); err != nil {
+				return err
+			}
+		}
+	case "":
+		return configErr(key+".type", "sink type is required")
+	default:
+		return configErr(key+".type", "invalid sink type %q: must be api, agent, stdout, file or failover", cfg.Type)
+	}
+	return nil
+}
+
+// sink creates the sink.
+func (cfg SinkConfig) sink() (Sink, error) {
+	switch cfg.Type {
+	case "api":
+		return APISink(), nil
+	case "agent":
+		return AgentSink(), nil
+	case "stdout":
+		return StdoutSink(), nil
+	case "file":
+		return FileSink(cfg.Path, cfg.MaxSize, cfg.MaxBackups)
+	}
+	sinks := make([]Sink, len(cfg.Sinks))
+	for i, s := range cfg.Sinks {
+		var err error
+		if sinks[i], err = s.sink(); err != nil {
+			return nil, err
+		}
+	}
+	return FailoverSink(time.Duration(cfg.Retry), sinks...), nil
+}
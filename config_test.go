@@ -0,0 +1,102 @@
+package sdhook
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestConfigErrorKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		key    string
+	}{
+		{"level", "levels: [info, loud]", "levels[1]"},
+		{"compute credentials", "credentials_file: a.json\ncompute_credentials: {}", "compute_credentials"},
+		{"log name rule", "log_name_rules: [{levels: [error]}]", "log_name_rules[0].name"},
+		{"max entry size", "max_entry_size: {size: 10, mode: drop}", "max_entry_size.mode"},
+		{"redact action", "redact: [{field: a}, {field: b}, {field: c, action: erase}]", "redact[2].action"},
+		{"redact value", "redact: [{value: '('}]", "redact[0].value"},
+		{"redact rule", "redact: [{field: a, glob: b}]", "redact[0]"},
+		{"route field", "routes: [{routes: {acme: {project_id: acme}}}]", "routes[0].field"},
+		{"route credentials", "routes: [{field: tenant, routes: {acme: {credentials: acme}}}]", "routes[0].routes.acme.credentials"},
+		{"route resource", "routes: [{field: tenant, routes: {acme: {resource: {}}}}]", "routes[0].routes.acme.resource.type"},
+		{"sink type", "sinks: [{type: stdout}, {type: pipe}]", "sinks[1].type"},
+		{"sink levels", "sinks: [{type: stdout, levels: [loud]}]", "sinks[0].levels[0]"},
+		{"failover sink path", "sinks: [{type: failover, sinks: [{type: api}, {type: file}]}]", "sinks[0].sinks[1].path"},
+		{"failover sinks", "sinks: [{type: failover}]", "sinks[0].sinks"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := ParseConfig([]byte(test.config))
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			_, err = cfg.Options()
+			var cerr *ConfigError
+			if !errors.As(err, &cerr) {
+				t.Fatalf("expected config error, got: %v", err)
+			}
+			if cerr.Key != test.key {
+				t.Errorf("expected key %q, got: %q", test.key, cerr.Key)
+			}
+		})
+	}
+}
+
+func TestConfigOptionErrorKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		key    string
+	}{
+		{"partial retries", "partial_retries: -1", "partial_retries"},
+		{"batching", "batching: {size: 0, interval: 1s}", "batching"},
+		{"trusted proxies", "trusted_proxies: [10.0.0.0/8, proxy]", "trusted_proxies"},
+		{"credentials file", "credentials_file: /nonexistent/credentials.json", "credentials_file"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := ParseConfig([]byte(test.config))
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			opts, err := cfg.Options()
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			_, err = New(opts...)
+			var cerr *ConfigError
+			if !errors.As(err, &cerr) {
+				t.Fatalf("expected config error, got: %v", err)
+			}
+			if cerr.Key != test.key {
+				t.Errorf("expected key %q, got: %q", test.key, cerr.Key)
+			}
+		})
+	}
+}
+
+func TestParseConfigUnknownKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		key    string
+	}{
+		{"top level", "project_id: project\nlog_nmae: app", "log_nmae"},
+		{"nested", "sinks: [{type: file, paht: app.log}]", "paht"},
+		{"json", `{"project_id": "project", "levels": ["info"], "bogus": true}`, "bogus"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseConfig([]byte(test.config))
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			if !strings.Contains(err.Error(), test.key) {
+				t.Errorf("expected error to name %q, got: %v", test.key, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package sdhook
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"google.golang.org/api/googleapi"
+	logging "google.golang.org/api/logging/v2"
+)
+
+// DefaultMaxEntrySize is the default maximum size of a log entry in bytes.
+//
+// See: https://cloud.google.com/logging/quotas#log-limits
+const DefaultMaxEntrySize = 256 * 1024
+
+// OversizeMode is the handling of log entries exceeding the maximum entry
+// size.
+type OversizeMode int
+
+// OversizeMode values.
+const (
+	// OversizeTruncate truncates the text or JSON payload of oversized log
+	// entries, appending a marker to the truncated values.
+	OversizeTruncate OversizeMode = iota
+	// OversizeSplit splits the text payload of oversized log entries across
+	// multiple log entries, linked by the log entry split field. Oversized
+	// log entries with a JSON payload, or sent to the logging agent, are
+	// truncated.
+	OversizeSplit
+)
+
+// entrySize returns the size of the log entry, as encoded in a write
+// request.
+func entrySize(le *logging.LogEntry) int {
+	buf, err := json.Marshal(le)
+	if err != nil {
+		return 0
+	}
+	return len(buf)
+}
+
+// fitEntry returns the log entries to write for the log entry, so that each
+// log entry is at most the maximum entry size. If the log entry cannot be
+// made to fit, it is returned as-is.
+func (h *Hook) fitEntry(le *logging.LogEntry) []*logging.LogEntry {
+	size := entrySize(le)
+	if h.maxEntrySize <= 0 || size <= h.maxEntrySize {
+		return []*logging.LogEntry{le}
+	}
+	excess := size - h.maxEntrySize
+	switch {
+	case le.JsonPayload != nil:
+		if payload, ok := truncatePayload(le.JsonPayload, excess); ok {
+			le.JsonPayload = payload
+		}
+	case h.oversizeMode == OversizeSplit:
+		// the text payload's encoded length is included in the size of the
+		// entry, so each part gets what remains
+		n := h.maxEntrySize - (size - jsonLen(le.TextPayload)) - splitOverhead(le)
+		if n > 0 {
+			return splitEntry(le, n)
+		}
+	default:
+		le.TextPayload = truncate(le.TextPayload, jsonLen(le.TextPayload)-excess, jsonSize)
+	}
+	return []*logging.LogEntry{le}
+}
+
+// fitAgentPayload truncates the message of the logging agent payload, so
+// that the encoded payload, including its labels and other fields, is at
+// most the maximum entry size. The logging agent does not support split log
+// entries, so oversized payloads are always truncated.
+func (h *Hook) fitAgentPayload(payload map[string]interface{}) {
+	msg, ok := payload["message"].(string)
+	if h.maxEntrySize <= 0 || !ok {
+		return
+	}
+	buf, err := json.Marshal(payload)
+	if err != nil || len(buf) <= h.maxEntrySize {
+		return
+	}
+	payload["message"] = truncate(msg, jsonLen(msg)-(len(buf)-h.maxEntrySize), jsonSize)
+}
+
+// splitEntry splits the text payload of the log entry into parts of at most
+// n encoded bytes, returning a log entry for each part.
+func splitEntry(le *logging.LogEntry, n int) []*logging.LogEntry {
+	parts := splitString(le.TextPayload, n)
+	entries := make([]*logging.LogEntry, len(parts))
+	for i, s := range parts {
+		e := *le
+		e.TextPayload = s
+		e.Split = &logging.LogSplit{
+			Uid:         le.InsertId,
+			Index:       int64(i),
+			TotalSplits: int64(len(parts)),
+		}
+		if le.InsertId != "" {
+			e.InsertId = le.InsertId + "-" + strconv.Itoa(i)
+		}
+		entries[i] = &e
+	}
+	return entries
+}
+
+// splitOverhead returns the maximum number of bytes added to the log entry
+// by splitEntry.
+func splitOverhead(le *logging.LogEntry) int {
+	return entrySize(&logging.LogEntry{
+		Split: &logging.LogSplit{
+			Uid:         le.InsertId,
+			Index:       1 << 31,
+			TotalSplits: 1 << 31,
+		},
+	}) + len(",-2147483648")
+}
+
+// truncatePayload truncates the largest top-level string values of the JSON
+// payload until the payload has been reduced by excess bytes.
+func truncatePayload(payload googleapi.RawMessage, excess int) (googleapi.RawMessage, bool) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return nil, false
+	}
+	type field struct {
+		key string
+		n   int
+	}
+	var fields []field
+	for k, v := range m {
+		if s, ok := v.(string); ok {
+			fields = append(fields, field{k, jsonLen(s)})
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].n > fields[j].n
+	})
+	for _, f := range fields {
+		if excess <= 0 {
+			break
+		}
+		s := m[f.key].(string)
+		n := f.n - excess
+		if n < len(truncatedMarker) {
+			n = len(truncatedMarker)
+		}
+		m[f.key] = truncate(s, n, jsonSize)
+		excess -= f.n - jsonLen(m[f.key].(string))
+	}
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return nil, false
+	}
+	return buf, true
+}
+
+// splitString splits s into parts whose JSON encoded length is at most n
+// bytes, without splitting a UTF-8 encoded rune.
+func splitString(s string, n int) []string {
+	var parts []string
+	var start, l int
+	for i, r := range s {
+		rl := jsonRuneLen(r)
+		if l+rl > n && i > start {
+			parts = append(parts, s[start:i])
+			start, l = i, 0
+		}
+		l += rl
+	}
+	return append(parts, s[start:])
+}
+
+// jsonLen returns the length of s when encoded as a JSON string, excluding
+// the quotes.
+func jsonLen(s string) int {
+	var n int
+	for _, r := range s {
+		n += jsonRuneLen(r)
+	}
+	return n
+}
+
+// jsonRuneLen returns the length of the rune when encoded in a JSON string
+// by encoding/json.
+func jsonRuneLen(r rune) int {
+	switch {
+	case r == '"' || r == '\\' || r == '\n' || r == '\r' || r == '\t':
+		return 2
+	case r < 0x20 || r == '<' || r == '>' || r == '&' || r == '\u2028' || r == '\u2029' || r == utf8.RuneError:
+		return 6
+	}
+	return utf8.RuneLen(r)
+}
@@ -0,0 +1,122 @@
+package sdhook
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	logging "google.golang.org/api/logging/v2"
+)
+
+func TestSplitString(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		exp  []string
+	}{
+		{"empty", "", 4, []string{""}},
+		{"fits", "abcd", 4, []string{"abcd"}},
+		{"ascii", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"multibyte", "ééééé", 4, []string{"éé", "éé", "é"}},
+		{"rune boundary", "aéé", 4, []string{"aé", "é"}},
+		{"escaped", `a"b"c`, 3, []string{`a"`, `b"`, "c"}},
+		{"html", "a<b", 6, []string{"a", "<", "b"}},
+		{"larger than n", "€", 2, []string{"€"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parts := splitString(test.s, test.n)
+			if strings.Join(parts, "") != test.s {
+				t.Errorf("expected parts to join to %q, got: %q", test.s, parts)
+			}
+			if len(parts) != len(test.exp) {
+				t.Fatalf("expected %q, got: %q", test.exp, parts)
+			}
+			for i, s := range parts {
+				if s != test.exp[i] {
+					t.Errorf("expected part %d to be %q, got: %q", i, test.exp[i], s)
+				}
+			}
+		})
+	}
+}
+
+func TestFitEntry(t *testing.T) {
+	long := strings.Repeat("x", 1000)
+	tests := []struct {
+		name    string
+		max     int
+		mode    OversizeMode
+		entry   logging.LogEntry
+		entries int
+	}{
+		{"disabled", 0, OversizeTruncate, logging.LogEntry{TextPayload: long}, 1},
+		{"fits", 2000, OversizeTruncate, logging.LogEntry{TextPayload: long}, 1},
+		{"truncate", 500, OversizeTruncate, logging.LogEntry{TextPayload: long}, 1},
+		{"truncate escaped", 500, OversizeTruncate, logging.LogEntry{TextPayload: strings.Repeat("<", 500)}, 1},
+		{"split", 500, OversizeSplit, logging.LogEntry{TextPayload: long, InsertId: "id"}, 3},
+		{"split escaped", 500, OversizeSplit, logging.LogEntry{TextPayload: strings.Repeat("<", 500), InsertId: "id"}, 8},
+		{"json", 500, OversizeTruncate, logging.LogEntry{JsonPayload: jsonPayload(t, map[string]interface{}{"message": long, "n": 1})}, 1},
+		{"json split", 500, OversizeSplit, logging.LogEntry{JsonPayload: jsonPayload(t, map[string]interface{}{"message": long, "n": 1})}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := &Hook{
+				maxEntrySize: test.max,
+				oversizeMode: test.mode,
+			}
+			orig := test.entry
+			entries := h.fitEntry(&test.entry)
+			if len(entries) != test.entries {
+				t.Fatalf("expected %d entries, got: %d", test.entries, len(entries))
+			}
+			var text string
+			for i, le := range entries {
+				if n := entrySize(le); test.max > 0 && n > test.max {
+					t.Errorf("expected entry %d size to be at most %d, got: %d", i, test.max, n)
+				}
+				text += le.TextPayload
+				if len(entries) > 1 {
+					if le.Split == nil || le.Split.Index != int64(i) || le.Split.TotalSplits != int64(len(entries)) || le.Split.Uid != orig.InsertId {
+						t.Errorf("expected entry %d to be split %d of %d, got: %+v", i, i, len(entries), le.Split)
+					}
+					if exp := orig.InsertId + "-" + strconv.Itoa(i); le.InsertId != exp {
+						t.Errorf("expected entry %d insert id %q, got: %q", i, exp, le.InsertId)
+					}
+				}
+			}
+			switch {
+			case orig.JsonPayload != nil:
+				var m map[string]interface{}
+				if err := json.Unmarshal(entries[0].JsonPayload, &m); err != nil {
+					t.Fatalf("expected no error, got: %v", err)
+				}
+				if m["n"] != float64(1) {
+					t.Errorf("expected non-string values to be kept, got: %v", m["n"])
+				}
+				if s, _ := m["message"].(string); !strings.HasSuffix(s, truncatedMarker) {
+					t.Errorf("expected message to be truncated, got: %q", s)
+				}
+			case len(entries) > 1 || entrySize(&orig) <= test.max || test.max == 0:
+				if text != orig.TextPayload {
+					t.Errorf("expected text payload to be kept")
+				}
+			default:
+				if !strings.HasSuffix(text, truncatedMarker) {
+					t.Errorf("expected text payload to be truncated, got: %q", text)
+				}
+			}
+		})
+	}
+}
+
+// jsonPayload encodes the JSON payload.
+func jsonPayload(t *testing.T, v interface{}) []byte {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	return buf
+}
@@ -0,0 +1,165 @@
+package sdhook
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/sirupsen/logrus"
+)
+
+// Environment variables read by FromEnv.
+const (
+	// EnvProjectID is the environment variable for the project ID.
+	EnvProjectID = "SDHOOK_PROJECT_ID"
+	// EnvLogName is the environment variable for the log name.
+	EnvLogName = "SDHOOK_LOG_NAME"
+	// EnvLevel is the environment variable for the minimum level, such as
+	// "info".
+	EnvLevel = "SDHOOK_LEVEL"
+	// EnvTransport is the environment variable for the transport, one of
+	// "api" (the default), "agent" or "stdout".
+	EnvTransport = "SDHOOK_TRANSPORT"
+	// EnvCredentialsFile is the environment variable for the path of the
+	// Google Service Account credentials file.
+	EnvCredentialsFile = "SDHOOK_CREDENTIALS_FILE"
+	// EnvLabels is the environment variable for the common labels, as
+	// comma separated key=value pairs.
+	EnvLabels = "SDHOOK_LABELS"
+	// EnvErrorService is the environment variable for the error reporting
+	// service name.
+	EnvErrorService = "SDHOOK_ERROR_SERVICE"
+)
+
+// NewFromEnv creates a hook configured from the environment (see FromEnv),
+// followed by the options.
+func NewFromEnv(opts ...Option) (*Hook, error) {
+	return New(append([]Option{FromEnv()}, opts...)...)
+}
+
+// FromEnv is an option that configures the hook from the environment
+// variables:
+//
+//	SDHOOK_TRANSPORT         api (default), agent or stdout
+//	SDHOOK_CREDENTIALS_FILE  Google Service Account credentials file
+//	SDHOOK_PROJECT_ID        project ID
+//	SDHOOK_LOG_NAME          log name
+//	SDHOOK_LEVEL             minimum level, such as info
+//	SDHOOK_LABELS            common labels, such as env=prod,team=core
+//	SDHOOK_ERROR_SERVICE     error reporting service name
+//
+// With the api transport, the credentials and project of the GCE compute
+// instance are used when SDHOOK_CREDENTIALS_FILE is not set, and an error is
+// returned when not running on GCE. When SDHOOK_PROJECT_ID is set (or read
+// from GCE) and no monitored resource was set by the credentials, the project
+// is used as the monitored resource.
+func FromEnv() Option {
+	return fromEnv(os.Getenv)
+}
+
+// envOption is an option configured by an environment variable.
+type envOption struct {
+	name string
+	opt  Option
+}
+
+// fromEnv configures the hook from the environment variables returned by
+// getenv.
+func fromEnv(getenv func(string) string) Option {
+	return func(h *Hook) error {
+		var opts []envOption
+		// credentials and transport
+		file := getenv(EnvCredentialsFile)
+		if file != "" {
+			opts = append(opts, envOption{EnvCredentialsFile, GoogleServiceAccountCredentialsFile(file)})
+		}
+		projectID := getenv(EnvProjectID)
+		switch transport := getenv(EnvTransport); transport {
+		case "", "api":
+			if file != "" {
+				break
+			}
+			if !metadata.OnGCE() {
+				return fmt.Errorf("%s must be set when not running on GCE", EnvCredentialsFile)
+			}
+			opts = append(opts, envOption{EnvCredentialsFile, func(h *Hook) error {
+				if err := GoogleComputeCredentials("")(h); err != nil {
+					return fmt.Errorf("not set, and the GCE compute credentials cannot be used: %w", err)
+				}
+				return nil
+			}})
+			if projectID == "" {
+				var err error
+				if projectID, err = metadata.ProjectID(); err != nil {
+					return fmt.Errorf("%s: not set, and the project ID cannot be read from the GCE metadata server: %w", EnvProjectID, err)
+				}
+			}
+		case "agent":
+			opts = append(opts, envOption{EnvTransport, GoogleLoggingAgent()})
+		case "stdout":
+			opts = append(opts, envOption{EnvTransport, AddSink(StdoutSink())})
+		default:
+			return fmt.Errorf("invalid %s %q: must be api, agent or stdout", EnvTransport, transport)
+		}
+		// project
+		if projectID != "" {
+			opts = append(opts, envOption{EnvProjectID, func(h *Hook) error {
+				h.projectID = projectID
+				if h.resource == nil {
+					return Resource(ResTypeProject, map[string]string{
+						"project_id": projectID,
+					})(h)
+				}
+				return nil
+			}})
+		}
+		// log name, after the project
+		if name := getenv(EnvLogName); name != "" {
+			opts = append(opts, envOption{EnvLogName, LogName(name)})
+		}
+		// level
+		if s := getenv(EnvLevel); s != "" {
+			level, err := logrus.ParseLevel(s)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q: %v", EnvLevel, s, err)
+			}
+			opts = append(opts, envOption{EnvLevel, Levels(logrus.AllLevels[:level+1]...)})
+		}
+		// labels
+		if s := getenv(EnvLabels); s != "" {
+			labels, err := parseLabels(s)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q: %v", EnvLabels, s, err)
+			}
+			opts = append(opts, envOption{EnvLabels, Labels(labels)})
+		}
+		// error reporting
+		if service := getenv(EnvErrorService); service != "" {
+			opts = append(opts, envOption{EnvErrorService, ErrorReportingService(service)})
+		}
+		for _, o := range opts {
+			if err := o.opt(h); err != nil {
+				return fmt.Errorf("%s: %w", o.name, err)
+			}
+		}
+		return nil
+	}
+}
+
+// parseLabels parses comma separated key=value pairs.
+func parseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		i := strings.IndexByte(kv, '=')
+		if i < 1 {
+			return nil, fmt.Errorf("label %q is not a key=value pair", kv)
+		}
+		labels[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+	}
+	return labels, nil
+}
@@ -0,0 +1,178 @@
+package sdhook
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/sirupsen/logrus"
+)
+
+// credentialsFile writes Google Service Account credentials for the project
+// to a temporary file, returning its path.
+func credentialsFile(t *testing.T, projectID string) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	buf, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     projectID,
+		"private_key_id": "key",
+		"private_key": string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		})),
+		"client_email": "sdhook@" + projectID + ".iam.gserviceaccount.com",
+		"client_id":    "1",
+		"auth_uri":     "https://accounts.google.com/o/oauth2/auth",
+		"token_uri":    "https://oauth2.googleapis.com/token",
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	if err := os.WriteFile(path, buf, 0o600); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	return path
+}
+
+func TestFromEnv(t *testing.T) {
+	file := credentialsFile(t, "file-project")
+	tests := []struct {
+		name  string
+		env   map[string]string
+		err   string
+		check func(*testing.T, *Hook)
+	}{
+		{
+			"api", map[string]string{EnvCredentialsFile: file, EnvLogName: "app"}, "",
+			func(t *testing.T, h *Hook) {
+				if h.projectID != "file-project" {
+					t.Errorf("expected project %q, got: %q", "file-project", h.projectID)
+				}
+				if h.logName != "projects/file-project/logs/app" {
+					t.Errorf("expected log name %q, got: %q", "projects/file-project/logs/app", h.logName)
+				}
+				if h.service == nil {
+					t.Errorf("expected service to be set")
+				}
+			},
+		},
+		{
+			"api project", map[string]string{EnvTransport: "api", EnvCredentialsFile: file, EnvProjectID: "other"}, "",
+			func(t *testing.T, h *Hook) {
+				if h.projectID != "other" {
+					t.Errorf("expected project %q, got: %q", "other", h.projectID)
+				}
+			},
+		},
+		{
+			"api bad credentials", map[string]string{EnvCredentialsFile: filepath.Join(t.TempDir(), "missing.json")}, EnvCredentialsFile,
+			nil,
+		},
+		{
+			"agent", map[string]string{EnvTransport: "agent"}, "",
+			func(t *testing.T, h *Hook) {
+				if h.agentClient == nil {
+					t.Errorf("expected agent client to be set")
+				}
+			},
+		},
+		{
+			"stdout", map[string]string{EnvTransport: "stdout", EnvLevel: "warn", EnvLabels: "env=prod, team=core", EnvErrorService: "app"}, "",
+			func(t *testing.T, h *Hook) {
+				if len(h.sinks) != 1 {
+					t.Fatalf("expected 1 sink, got: %d", len(h.sinks))
+				}
+				if exp := logrus.AllLevels[:logrus.WarnLevel+1]; !reflect.DeepEqual(h.levels, exp) {
+					t.Errorf("expected levels %v, got: %v", exp, h.levels)
+				}
+				if exp := map[string]string{"env": "prod", "team": "core"}; !reflect.DeepEqual(h.labels, exp) {
+					t.Errorf("expected labels %v, got: %v", exp, h.labels)
+				}
+				if h.errorReportingServiceName != "app" {
+					t.Errorf("expected error reporting service %q, got: %q", "app", h.errorReportingServiceName)
+				}
+			},
+		},
+		{"invalid transport", map[string]string{EnvTransport: "udp"}, EnvTransport, nil},
+		{"invalid level", map[string]string{EnvTransport: "stdout", EnvLevel: "loud"}, EnvLevel, nil},
+		{"invalid labels", map[string]string{EnvTransport: "stdout", EnvLabels: "env"}, EnvLabels, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, err := New(fromEnv(func(k string) string {
+				return test.env[k]
+			}))
+			if test.err != "" {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				if !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected error to name %s, got: %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			t.Cleanup(func() { h.Close() })
+			test.check(t, h)
+		})
+	}
+}
+
+func TestFromEnvNotOnGCE(t *testing.T) {
+	if metadata.OnGCE() {
+		t.Skip("running on GCE")
+	}
+	_, err := New(fromEnv(func(string) string { return "" }))
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if !strings.Contains(err.Error(), EnvCredentialsFile) {
+		t.Errorf("expected error to name %s, got: %v", EnvCredentialsFile, err)
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp map[string]string
+		err bool
+	}{
+		{"", map[string]string{}, false},
+		{"a=b", map[string]string{"a": "b"}, false},
+		{" a = b , c=d ,", map[string]string{"a": "b", "c": "d"}, false},
+		{"a=", map[string]string{"a": ""}, false},
+		{"a=b=c", map[string]string{"a": "b=c"}, false},
+		{"a", nil, true},
+		{"=b", nil, true},
+		{"a=b,c", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			labels, err := parseLabels(test.s)
+			switch {
+			case test.err && err == nil:
+				t.Fatalf("expected error")
+			case !test.err && err != nil:
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if !reflect.DeepEqual(labels, test.exp) {
+				t.Errorf("expected %v, got: %v", test.exp, labels)
+			}
+		})
+	}
+}
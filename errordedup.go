@@ -0,0 +1,198 @@
+package sdhook
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	errorReporting "google.golang.org/api/clouderrorreporting/v1beta1"
+)
+
+// DefaultErrorDeduplicationSize is the default number of error fingerprints
+// tracked for error deduplication.
+const DefaultErrorDeduplicationSize = 1024
+
+// errorDedup collapses repeated error reports.
+type errorDedup struct {
+	window time.Duration
+	size   int
+	// due is called when the window of a repeated error has ended, so that
+	// its follow-up report is sent without waiting for another error.
+	due func()
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// dedupItem is a tracked error fingerprint.
+type dedupItem struct {
+	key   string
+	start time.Time
+	// count is the number of repeats since start.
+	count uint64
+	// entry and event are the last repeat.
+	entry *logrus.Entry
+	event errorReporting.ReportedErrorEvent
+}
+
+// newErrorDedup creates an error deduplicator, calling due when the window
+// of a repeated error has ended.
+func newErrorDedup(window time.Duration, size int, due func()) *errorDedup {
+	return &errorDedup{
+		window: window,
+		size:   size,
+		due:    due,
+		ll:     list.New(),
+		items:  make(map[string]*list.Element),
+	}
+}
+
+// check tracks the error event with the key, returning true if it should be
+// reported. It also returns the follow-up reports due for errors whose
+// window has ended.
+func (d *errorDedup) check(key string, entry *logrus.Entry, event errorReporting.ReportedErrorEvent, now time.Time) (bool, []dedupItem) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	followUps := d.expired(now, false)
+	if el, ok := d.items[key]; ok {
+		d.ll.MoveToFront(el)
+		item := el.Value.(*dedupItem)
+		if now.Sub(item.start) < d.window {
+			item.count++
+			item.entry, item.event = entry, event
+			if item.count == 1 && d.due != nil {
+				time.AfterFunc(item.start.Add(d.window).Sub(now), d.due)
+			}
+			return false, followUps
+		}
+		item.start, item.count = now, 0
+		return true, followUps
+	}
+	d.items[key] = d.ll.PushFront(&dedupItem{
+		key:   key,
+		start: now,
+	})
+	for d.ll.Len() > d.size {
+		el := d.ll.Back()
+		item := el.Value.(*dedupItem)
+		if item.count != 0 {
+			followUps = append(followUps, *item)
+		}
+		d.ll.Remove(el)
+		delete(d.items, item.key)
+	}
+	return true, followUps
+}
+
+// flush returns the follow-up reports due for errors whose window has
+// ended, or for all repeated errors when force is true.
+func (d *errorDedup) flush(now time.Time, force bool) []dedupItem {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.expired(now, force)
+}
+
+// expired returns the follow-up reports for repeated errors whose window
+// has ended, or for all repeated errors when force is true, resetting their
+// counts.
+func (d *errorDedup) expired(now time.Time, force bool) []dedupItem {
+	var followUps []dedupItem
+	for el := d.ll.Front(); el != nil; el = el.Next() {
+		item := el.Value.(*dedupItem)
+		if item.count != 0 && (force || now.Sub(item.start) >= d.window) {
+			followUps = append(followUps, *item)
+			item.count = 0
+		}
+	}
+	return followUps
+}
+
+// annotate returns the follow-up report's event, with the number of repeats
+// added to the first line of the message.
+func (item dedupItem) annotate() errorReporting.ReportedErrorEvent {
+	event := item.event
+	note := fmt.Sprintf(" (repeated %d times since %s)", item.count, item.start.Format(time.RFC3339))
+	if i := strings.IndexByte(event.Message, '\n'); i != -1 {
+		event.Message = event.Message[:i] + note + event.Message[i:]
+	} else {
+		event.Message += note
+	}
+	return event
+}
+
+// stackNoiseRE matches the parts of a Go stack trace that differ between
+// occurrences of the same error.
+var stackNoiseRE = regexp.MustCompile(`goroutine \d+|0x[0-9a-f]+`)
+
+// fingerprint returns the fingerprint of the error event, made from its
+// message, stack trace and report location.
+func fingerprint(event errorReporting.ReportedErrorEvent) string {
+	h := sha256.New()
+	h.Write([]byte(stackNoiseRE.ReplaceAllString(event.Message, "")))
+	if event.Context != nil && event.Context.ReportLocation != nil {
+		loc := event.Context.ReportLocation
+		fmt.Fprintf(h, "\x00%s:%d:%s", loc.FilePath, loc.LineNumber, loc.FunctionName)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// reportError reports the record's error event, unless it is a repeat
+// within the error deduplication window. Follow-up reports for repeated
+// errors are reported asynchronously.
+func (h *Hook) reportError(r *record, event errorReporting.ReportedErrorEvent) {
+	entry := r.entry
+	if h.errorDedup == nil {
+		h.report(entry, event, r)
+		return
+	}
+	// errors are deduplicated per project, as routes may report them to
+	// different projects
+	h.mu.RLock()
+	key := h.route(entry).ProjectID + "\x00" + fingerprint(event)
+	h.mu.RUnlock()
+	ok, followUps := h.errorDedup.check(key, entry, event, time.Now())
+	h.reportFollowUps(followUps)
+	if !ok {
+		h.stats.drop(DropDuplicate)
+		return
+	}
+	h.report(entry, event, r)
+}
+
+// dueErrorReports reports the follow-ups for repeated errors whose window
+// has ended.
+func (h *Hook) dueErrorReports() {
+	if h.closed.Load() {
+		// reported by Shutdown
+		return
+	}
+	h.reportFollowUps(h.errorDedup.flush(time.Now(), false))
+}
+
+// flushErrorReports reports the follow-ups for all repeated errors.
+func (h *Hook) flushErrorReports() {
+	if h.errorDedup != nil {
+		h.reportFollowUps(h.errorDedup.flush(time.Now(), true))
+	}
+}
+
+// reportFollowUps asynchronously reports the follow-ups for repeated
+// errors.
+func (h *Hook) reportFollowUps(followUps []dedupItem) {
+	for _, item := range followUps {
+		h.waitGroup.Add(1)
+		e := h.pending.add()
+		go func(item dedupItem) {
+			defer h.waitGroup.Done()
+			defer h.pending.finish(e)
+			h.report(item.entry, item.annotate(), nil)
+		}(item)
+	}
+}
@@ -0,0 +1,65 @@
+package sdhook
+
+import (
+	"log"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Transport names used in delivery errors.
+const (
+	// TransportAPI is the Stackdriver logging API transport.
+	TransportAPI = "api"
+	// TransportAgent is the Google logging agent transport.
+	TransportAgent = "agent"
+	// TransportErrorReporting is the Stackdriver error reporting API
+	// transport.
+	TransportErrorReporting = "error_reporting"
+)
+
+// DeliveryError is an error encountered by the hook while delivering a log
+// entry.
+type DeliveryError struct {
+	// Transport is the name of the transport delivering the log entry (see
+	// TransportAPI, TransportAgent and TransportErrorReporting), or the name
+	// of the sink.
+	Transport string
+	// Entry is the logrus entry being delivered.
+	Entry *logrus.Entry
+	// Attempt is the number of times delivery was attempted.
+	Attempt int
+	// Dropped indicates whether the log entry was dropped. When false, the
+	// log entry was delivered in a degraded form (for example, with a text
+	// payload instead of a JSON payload).
+	Dropped bool
+	// Reason is the drop reason when the log entry was dropped (see
+	// DropWriteFailed, DropRejected, DropAgentFailed, DropReportFailed,
+	// DropNotConfigured, DropSinkFailed, DropCircuitOpen and DropClosed).
+	Reason string
+	// Err is the underlying error.
+	Err error
+}
+
+// Error satisfies the error interface.
+func (err *DeliveryError) Error() string {
+	return err.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (err *DeliveryError) Unwrap() error {
+	return err.Err
+}
+
+// handleError counts dropped log entries, and passes the delivery error to
+// the error handler.
+func (h *Hook) handleError(err *DeliveryError) {
+	if err.Dropped {
+		h.stats.drop(err.Reason)
+	}
+	h.lastError.set(err)
+	if h.errorHandler != nil {
+		h.errorHandler(err)
+		return
+	}
+	log.Println(err)
+}
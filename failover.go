@@ -0,0 +1,347 @@
+package sdhook
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logging "google.golang.org/api/logging/v2"
+)
+
+// DefaultFailoverRetry is the default interval after which a failed sink of
+// a failover chain is probed again.
+const DefaultFailoverRetry = 30 * time.Second
+
+// Prober is implemented by sinks that can check their health. Failed sinks
+// of a failover chain implementing Prober are probed before being used
+// again.
+type Prober interface {
+	Probe() error
+}
+
+// hookProber is implemented by the hook's sinks that can check their
+// health.
+type hookProber interface {
+	probe(h *Hook) error
+}
+
+// failoverSink is a failover chain of sinks.
+type failoverSink struct {
+	sinks []Sink
+	retry time.Duration
+
+	mu sync.Mutex
+	// retryAt are the times after which failed sinks are used again, zero
+	// for healthy sinks.
+	retryAt []time.Time
+}
+
+// failoverAttempt is the delivery of a record by a sink of a failover
+// chain.
+type failoverAttempt struct {
+	sink  *failoverSink
+	index int
+	// orig is the record before delivery, as sinks may modify it.
+	orig *record
+}
+
+// FailoverSink returns a sink delivering each log entry to the first healthy
+// sink of the chain, such as APISink, AgentSink and a FileSink. When
+// delivery fails, the sink is marked as failed and the log entry is passed
+// to the next sink.
+//
+// Failed sinks are probed again after the retry interval (defaulting to
+// DefaultFailoverRetry when 0), and used again once they recover. Sinks
+// implementing Prober are probed with Probe, other sinks by delivering the
+// next log entry. The last sink of the chain is always used.
+//
+// The number of log entries delivered by each sink of failover chains is
+// available in Stats.Delivered.
+func FailoverSink(retry time.Duration, sinks ...Sink) Sink {
+	if retry <= 0 {
+		retry = DefaultFailoverRetry
+	}
+	return &failoverSink{
+		sinks:   sinks,
+		retry:   retry,
+		retryAt: make([]time.Time, len(sinks)),
+	}
+}
+
+// Name satisfies the Sink interface.
+func (f *failoverSink) Name() string {
+	return "failover"
+}
+
+// Send satisfies the Sink interface.
+func (f *failoverSink) Send(entry *logrus.Entry) error {
+	var err error
+	for i, s := range f.sinks {
+		if !f.available(nil, i, time.Now()) {
+			continue
+		}
+		if err = s.Send(entry); err == nil {
+			f.up(i)
+			return nil
+		}
+		f.down(i)
+	}
+	if err == nil {
+		err = errors.New("no sink available")
+	}
+	return err
+}
+
+// send satisfies the recordSink interface.
+func (f *failoverSink) send(h *Hook, r *record) {
+	f.sendFrom(h, r, 0, nil)
+}
+
+// sendFrom delivers the record to the first available sink of the chain,
+// starting at i. When no sink is left, the record is dropped with the last
+// error.
+func (f *failoverSink) sendFrom(h *Hook, r *record, i int, lastErr error) {
+	for ; i < len(f.sinks); i++ {
+		if !f.available(h, i, time.Now()) {
+			continue
+		}
+		s, rc := f.sinks[i], *r
+		rc.failover = &failoverAttempt{
+			sink:  f,
+			index: i,
+			orig:  r,
+		}
+		if rs, ok := s.(recordSink); ok {
+			// the result is passed to delivered or failed
+			rs.send(h, &rc)
+			return
+		}
+		err := s.Send(rc.sinkEntry())
+		if err == nil {
+			h.stats.written.Add(1)
+			h.delivered(&rc)
+			return
+		}
+		f.down(i)
+		h.stats.failed.Add(1)
+		h.stats.failovers.Add(1)
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no sink available")
+	}
+	h.handleError(&DeliveryError{
+		Transport: f.Name(),
+		Entry:     r.entry,
+		Attempt:   1,
+		Dropped:   true,
+		Reason:    DropSinkFailed,
+		Err:       fmt.Errorf("cannot deliver log entry to failover sinks: %w", lastErr),
+	})
+}
+
+// available returns true if sink i can be used. Failed sinks are probed
+// once their retry time has passed.
+func (f *failoverSink) available(h *Hook, i int, now time.Time) bool {
+	if i == len(f.sinks)-1 {
+		return true
+	}
+	f.mu.Lock()
+	retryAt := f.retryAt[i]
+	if retryAt.IsZero() {
+		f.mu.Unlock()
+		return true
+	}
+	if now.Before(retryAt) {
+		f.mu.Unlock()
+		return false
+	}
+	// only one probe at a time
+	f.retryAt[i] = now.Add(f.retry)
+	f.mu.Unlock()
+	var err error
+	switch p := f.sinks[i].(type) {
+	case hookProber:
+		if h != nil {
+			err = p.probe(h)
+		}
+	case Prober:
+		err = p.Probe()
+	}
+	return err == nil
+}
+
+// up marks sink i as healthy.
+func (f *failoverSink) up(i int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.retryAt[i] = time.Time{}
+}
+
+// down marks sink i as failed.
+func (f *failoverSink) down(i int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.retryAt[i] = time.Now().Add(f.retry)
+}
+
+// delivered records the delivery of the record by a sink of a failover
+// chain.
+func (h *Hook) delivered(r *record) {
+	if fa := r.failover; fa != nil {
+		fa.sink.up(fa.index)
+		h.stats.deliver(fa.sink.sinks[fa.index].Name())
+	}
+}
+
+// failed passes the record to the next sink of its failover chain after a
+// failed delivery, returning false if the record is not delivered by a
+// failover chain.
+func (h *Hook) failed(r *record, err error) bool {
+	fa := r.failover
+	if fa == nil {
+		return false
+	}
+	fa.sink.down(fa.index)
+	h.stats.failovers.Add(1)
+	fa.sink.sendFrom(h, fa.orig, fa.index+1, err)
+	return true
+}
+
+// probe satisfies the hookProber interface, writing a log entry to the
+// logging API with dry run enabled.
+func (apiSink) probe(h *Hook) error {
+	if h.service == nil {
+		return errors.New("no stackdriver service was provided")
+	}
+	h.mu.RLock()
+	req := &logging.WriteLogEntriesRequest{
+		LogName:  h.logName,
+		Resource: h.resource,
+		Entries: []*logging.LogEntry{{
+			TextPayload: "probe",
+		}},
+		DryRun: true,
+	}
+	h.mu.RUnlock()
+	ctx, cancel := h.callContext(h.writeTimeout)
+	defer cancel()
+	_, err := h.service.Write(req).Context(ctx).Do()
+	return err
+}
+
+// rotatingFile is a file rotated when reaching a maximum size.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	f    *os.File
+	size int64
+}
+
+// FileSink returns a sink appending log entries to the file as lines of JSON
+// (see JSONSink). When the file reaches maxSize bytes, it is rotated to
+// path.1, with up to maxBackups rotated files kept. A maxSize of 0 disables
+// rotation.
+func FileSink(path string, maxSize int64, maxBackups int) (Sink, error) {
+	if maxSize < 0 || maxBackups < 0 {
+		return nil, errors.New("invalid file sink rotation")
+	}
+	rf := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return &jsonSink{
+		name:   "file",
+		w:      rf,
+		closer: rf,
+	}, nil
+}
+
+// open opens the file for appending.
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, fi.Size()
+	return nil
+}
+
+// Write satisfies the io.Writer interface. Writes are serialized by the
+// JSON sink.
+func (rf *rotatingFile) Write(buf []byte) (int, error) {
+	if rf.f == nil {
+		// reopen after a failed rotation
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(buf)) > rf.maxSize {
+		if err := rf.rotate(); err != nil && rf.f == nil {
+			return 0, err
+		}
+		// when the rotation failed but the file was reopened, writing
+		// continues to the current file, and the rotation is retried on the
+		// next write
+	}
+	n, err := rf.f.Write(buf)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate rotates the file, and opens a new file. The file is reopened even
+// when rotating fails, so that writes are not stopped.
+func (rf *rotatingFile) rotate() error {
+	err := rf.f.Close()
+	rf.f = nil
+	if err == nil {
+		err = rf.rename()
+	}
+	if e := rf.open(); e != nil {
+		return e
+	}
+	return err
+}
+
+// rename renames the file and its backups.
+func (rf *rotatingFile) rename() error {
+	if rf.maxBackups == 0 {
+		if err := os.Remove(rf.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	for i := rf.maxBackups - 1; i > 0; i-- {
+		src := rf.path + "." + strconv.Itoa(i)
+		if err := os.Rename(src, rf.path+"."+strconv.Itoa(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(rf.path, rf.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Close closes the file.
+func (rf *rotatingFile) Close() error {
+	if rf.f == nil {
+		return nil
+	}
+	return rf.f.Close()
+}
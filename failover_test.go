@@ -0,0 +1,274 @@
+package sdhook
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testSink is a sink recording the messages delivered, failing while err is
+// set.
+type testSink struct {
+	name string
+
+	mu       sync.Mutex
+	err      error
+	attempts int
+	messages []string
+}
+
+// Name satisfies the Sink interface.
+func (s *testSink) Name() string {
+	return s.name
+}
+
+// Send satisfies the Sink interface.
+func (s *testSink) Send(entry *logrus.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	if s.err != nil {
+		return s.err
+	}
+	s.messages = append(s.messages, entry.Message)
+	return nil
+}
+
+// setErr sets the error returned by Send.
+func (s *testSink) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// counts returns the number of attempts and the number of messages
+// delivered.
+func (s *testSink) counts() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts, len(s.messages)
+}
+
+// probedSink is a test sink implementing Prober.
+type probedSink struct {
+	*testSink
+
+	mu     sync.Mutex
+	probes int
+}
+
+// Probe satisfies the Prober interface.
+func (s *probedSink) Probe() error {
+	s.mu.Lock()
+	s.probes++
+	s.mu.Unlock()
+	s.testSink.mu.Lock()
+	defer s.testSink.mu.Unlock()
+	return s.testSink.err
+}
+
+func TestFailoverSink(t *testing.T) {
+	const retry = 50 * time.Millisecond
+	primary := &testSink{name: "primary", err: errors.New("unavailable")}
+	secondary := &testSink{name: "secondary"}
+	h, err := New(AddSink(FailoverSink(retry, primary, secondary)))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	l := newTestLogger(h)
+	check := func(step string, primaryAttempts, primaryMessages, secondaryMessages int) {
+		t.Helper()
+		h.Wait()
+		if a, n := primary.counts(); a != primaryAttempts || n != primaryMessages {
+			t.Errorf("%s: expected primary %d attempts and %d messages, got: %d and %d", step, primaryAttempts, primaryMessages, a, n)
+		}
+		if _, n := secondary.counts(); n != secondaryMessages {
+			t.Errorf("%s: expected secondary %d messages, got: %d", step, secondaryMessages, n)
+		}
+	}
+	// the failed primary is skipped until the retry interval has passed
+	l.Info("first")
+	check("failover", 1, 0, 1)
+	l.Info("second")
+	check("skipped", 1, 0, 2)
+	primary.setErr(nil)
+	l.Info("third")
+	check("before retry", 1, 0, 3)
+	// the primary is probed by delivering the next entry, and used again
+	time.Sleep(2 * retry)
+	l.Info("fourth")
+	check("switch back", 2, 1, 3)
+	l.Info("fifth")
+	check("recovered", 3, 2, 3)
+	s := h.Stats()
+	if s.Delivered["primary"] != 2 || s.Delivered["secondary"] != 3 {
+		t.Errorf("expected 2 delivered by primary and 3 by secondary, got: %v", s.Delivered)
+	}
+	if s.Failovers != 1 || s.Written != 5 {
+		t.Errorf("expected 1 failover and 5 written, got: %d and %d", s.Failovers, s.Written)
+	}
+}
+
+func TestFailoverSinkProber(t *testing.T) {
+	const retry = 50 * time.Millisecond
+	primary := &probedSink{testSink: &testSink{name: "primary", err: errors.New("unavailable")}}
+	secondary := &testSink{name: "secondary"}
+	h, err := New(AddSink(FailoverSink(retry, primary, secondary)))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	l := newTestLogger(h)
+	l.Info("first")
+	h.Wait()
+	// the failed probe keeps the primary from being used
+	time.Sleep(2 * retry)
+	l.Info("second")
+	h.Wait()
+	if a, _ := primary.counts(); a != 1 {
+		t.Errorf("expected 1 primary attempt, got: %d", a)
+	}
+	// the successful probe switches back to the primary
+	primary.setErr(nil)
+	time.Sleep(2 * retry)
+	l.Info("third")
+	h.Wait()
+	if _, n := primary.counts(); n != 1 {
+		t.Errorf("expected 1 primary message, got: %d", n)
+	}
+	if _, n := secondary.counts(); n != 2 {
+		t.Errorf("expected 2 secondary messages, got: %d", n)
+	}
+	primary.mu.Lock()
+	defer primary.mu.Unlock()
+	if primary.probes != 2 {
+		t.Errorf("expected 2 probes, got: %d", primary.probes)
+	}
+}
+
+func TestFailoverSinkDropped(t *testing.T) {
+	primary := &testSink{name: "primary", err: errors.New("unavailable")}
+	secondary := &testSink{name: "secondary", err: errors.New("unavailable")}
+	var mu sync.Mutex
+	var errs []*DeliveryError
+	h, err := New(
+		AddSink(FailoverSink(time.Minute, primary, secondary)),
+		ErrorHandler(func(err *DeliveryError) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	newTestLogger(h).Info("message")
+	h.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) != 1 || errs[0].Reason != DropSinkFailed || !errs[0].Dropped {
+		t.Fatalf("expected 1 dropped delivery error, got: %v", errs)
+	}
+	if s := h.Stats(); s.Failed != 2 || len(s.Delivered) != 0 {
+		t.Errorf("expected 2 failed and none delivered, got: %d and %v", s.Failed, s.Delivered)
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxBackups int
+		files      []string
+		missing    []string
+	}{
+		{"no backups", 0, []string{"app.log"}, []string{"app.log.1"}},
+		{"backups", 2, []string{"app.log", "app.log.1", "app.log.2"}, []string{"app.log.3"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "app.log")
+			sink, err := FileSink(path, 100, test.maxBackups)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			defer closeSink(sink)
+			for i := 0; i < 10; i++ {
+				if err := sink.Send(testEntry("message")); err != nil {
+					t.Fatalf("expected no error, got: %v", err)
+				}
+			}
+			for _, name := range test.files {
+				fi, err := os.Stat(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatalf("expected no error, got: %v", err)
+				}
+				if fi.Size() == 0 || fi.Size() > 100 {
+					t.Errorf("expected %s size to be between 1 and 100, got: %d", name, fi.Size())
+				}
+			}
+			for _, name := range test.missing {
+				if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+					t.Errorf("expected %s to not exist, got: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestFileSinkRotationFailed(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	// a non-empty directory in place of the backup makes renaming fail
+	if err := os.MkdirAll(filepath.Join(path+".1", "dir"), 0o755); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	sink, err := FileSink(path, 100, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer closeSink(sink)
+	for i := 0; i < 5; i++ {
+		if err := sink.Send(testEntry("message")); err != nil {
+			t.Fatalf("expected writes to continue after a failed rotation, got: %v", err)
+		}
+	}
+	if n := strings.Count(readFile(t, path), "\n"); n != 5 {
+		t.Errorf("expected 5 lines in the current file, got: %d", n)
+	}
+	// the rotation is retried on the next write
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := sink.Send(testEntry("message")); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if n := strings.Count(readFile(t, path+".1"), "\n"); n != 5 {
+		t.Errorf("expected 5 lines in the rotated file, got: %d", n)
+	}
+	if n := strings.Count(readFile(t, path), "\n"); n != 1 {
+		t.Errorf("expected 1 line in the current file, got: %d", n)
+	}
+}
+
+// testEntry returns a logrus entry with the message.
+func testEntry(msg string) *logrus.Entry {
+	entry := logrus.NewEntry(logrus.New())
+	entry.Time, entry.Level, entry.Message = time.Now(), logrus.InfoLevel, msg
+	return entry
+}
+
+// readFile returns the contents of the file.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	return string(buf)
+}
@@ -0,0 +1,287 @@
+package sdhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logging "google.golang.org/api/logging/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// gRPC logrus field names used by the gRPC interceptors.
+const (
+	GRPCMethodField  = "grpc.method"
+	GRPCCodeField    = "grpc.code"
+	GRPCLatencyField = "grpc.latency"
+	GRPCPeerField    = "grpc.peer"
+	GRPCTargetField  = "grpc.target"
+)
+
+// UnaryServerInterceptor returns a gRPC unary server interceptor that writes
+// a request log entry to the logger for each call, including the method,
+// status code, latency, peer and the trace propagated in the incoming
+// metadata.
+//
+// A request-scoped logrus entry carrying the trace fields is attached to the
+// call's context, and can be retrieved with FromContext.
+//
+// Panics are recovered and logged at the error level with their stack trace,
+// and the call fails with codes.Internal. Calls failing with codes.Internal
+// are also logged at the error level, causing them to be sent to error
+// reporting when ErrorReportingService is set.
+func UnaryServerInterceptor(logger logrus.FieldLogger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (res interface{}, err error) {
+		start := time.Now()
+		entry := logger.WithFields(incomingTraceFields(ctx)).WithFields(logrus.Fields{
+			GRPCMethodField: info.FullMethod,
+			GRPCPeerField:   peerAddr(ctx),
+		})
+		defer func() {
+			if p := recover(); p != nil {
+				err = logPanic(entry, info.FullMethod, start, p, nil)
+				return
+			}
+			logCall(entry, info.FullMethod, start, err, nil)
+		}()
+		return handler(NewContext(ctx, entry), req)
+	}
+}
+
+// StreamServerInterceptor returns a gRPC stream server interceptor that
+// writes a log entry when each stream starts and ends, grouped by the
+// operation log entry field. The end entry includes the method, status code,
+// latency, peer and the trace propagated in the incoming metadata.
+//
+// A request-scoped logrus entry carrying the trace fields and the stream's
+// operation is attached to the stream's context, and can be retrieved with
+// FromContext. The operation can be retrieved with OperationFromContext.
+//
+// Panics and codes.Internal errors are handled the same as with
+// UnaryServerInterceptor.
+func StreamServerInterceptor(logger logrus.FieldLogger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		start := time.Now()
+		ctx := ss.Context()
+		entry := logger.WithFields(incomingTraceFields(ctx)).WithFields(logrus.Fields{
+			GRPCMethodField: info.FullMethod,
+			GRPCPeerField:   peerAddr(ctx),
+		})
+		op := NewOperation("", info.FullMethod)
+		op.Begin(entry).Info("stream started " + info.FullMethod)
+		defer func() {
+			if p := recover(); p != nil {
+				err = logPanic(entry, info.FullMethod, start, p, op)
+				return
+			}
+			logCall(entry, info.FullMethod, start, err, op)
+		}()
+		return handler(srv, &serverStream{
+			ServerStream: ss,
+			ctx:          WithOperation(NewContext(ctx, entry), op),
+		})
+	}
+}
+
+// UnaryClientInterceptor returns a gRPC unary client interceptor that writes
+// a log entry to the logger for each call, including the method, status
+// code, latency and target.
+//
+// When the call's context carries a logrus entry with trace fields (see
+// FromContext), the trace is propagated to the server in the outgoing
+// metadata and added to the log entry.
+func UnaryClientInterceptor(logger logrus.FieldLogger) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		fields := contextTraceFields(ctx)
+		entry := logger.WithFields(fields).WithField(GRPCTargetField, cc.Target())
+		err := invoker(outgoingTraceContext(ctx, fields), method, req, reply, cc, opts...)
+		logCall(entry, method, start, err, nil)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns a gRPC stream client interceptor that
+// writes a log entry when each stream starts and ends, grouped by the
+// operation log entry field. The end entry includes the method, status code,
+// latency and target.
+//
+// Trace fields are propagated the same as with UnaryClientInterceptor.
+func StreamClientInterceptor(logger logrus.FieldLogger) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		fields := contextTraceFields(ctx)
+		entry := logger.WithFields(fields).WithField(GRPCTargetField, cc.Target())
+		cs, err := streamer(outgoingTraceContext(ctx, fields), desc, cc, method, opts...)
+		if err != nil {
+			logCall(entry, method, start, err, nil)
+			return nil, err
+		}
+		op := NewOperation("", method)
+		op.Begin(entry.WithField(GRPCMethodField, method)).Info("stream started " + method)
+		return &clientStream{
+			ClientStream:  cs,
+			serverStreams: desc.ServerStreams,
+			finish: func(err error) {
+				logCall(entry, method, start, err, op)
+			},
+		}, nil
+	}
+}
+
+// logCall writes the log entry for a completed gRPC call. Calls that failed
+// with codes.Internal are logged at the error level, other failed calls at
+// the warning level, and successful calls at the info level.
+func logCall(entry *logrus.Entry, method string, start time.Time, err error, op *Operation) {
+	code := status.Code(err)
+	entry = callEntry(entry, method, start, code, op)
+	switch {
+	case err == nil:
+		entry.Info(method)
+	case code == codes.Internal:
+		entry.WithField(SourceLocationField, methodLocation(method)).Error(method + ": " + err.Error())
+	default:
+		entry.Warn(method + ": " + err.Error())
+	}
+}
+
+// logPanic writes the log entry for a gRPC call whose handler panicked,
+// including the panic's stack trace, and returns the codes.Internal error
+// sent to the client.
+func logPanic(entry *logrus.Entry, method string, start time.Time, p interface{}, op *Operation) error {
+	callEntry(entry, method, start, codes.Internal, op).
+		WithField(SourceLocationField, methodLocation(method)).
+		Errorf("panic: %v\n\n%s", p, debug.Stack())
+	return status.Errorf(codes.Internal, "panic: %v", p)
+}
+
+// methodLocation returns the source location reported for errors of a gRPC
+// call, identifying the call by its method, as the code that failed is not
+// known to the interceptors.
+func methodLocation(method string) *logging.LogEntrySourceLocation {
+	return &logging.LogEntrySourceLocation{
+		Function: method,
+	}
+}
+
+// callEntry adds the gRPC call fields to the logrus entry.
+func callEntry(entry *logrus.Entry, method string, start time.Time, code codes.Code, op *Operation) *logrus.Entry {
+	fields := logrus.Fields{
+		GRPCMethodField:  method,
+		GRPCCodeField:    code.String(),
+		GRPCLatencyField: time.Since(start).String(),
+	}
+	entry = entry.WithFields(fields)
+	if op != nil {
+		entry = op.End(entry)
+	}
+	return entry
+}
+
+// peerAddr returns the address of the peer stored in the context.
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// incomingTraceFields returns the logrus fields for the trace propagated in
+// the incoming gRPC metadata.
+func incomingTraceFields(ctx context.Context) logrus.Fields {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	get := func(key string) string {
+		if v := md.Get(key); len(v) != 0 {
+			return v[0]
+		}
+		return ""
+	}
+	return traceFields(get(TraceparentHeader), get(CloudTraceContextHeader))
+}
+
+// contextTraceFields returns the trace fields of the logrus entry attached
+// to the context.
+func contextTraceFields(ctx context.Context) logrus.Fields {
+	entry, ok := ctx.Value(entryKey).(*logrus.Entry)
+	if !ok {
+		return nil
+	}
+	fields := make(logrus.Fields)
+	for _, k := range []string{TraceField, SpanIDField, TraceSampledField} {
+		if v, ok := entry.Data[k]; ok {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// outgoingTraceContext returns a copy of the context with the trace fields
+// added to the outgoing gRPC metadata as a W3C traceparent.
+func outgoingTraceContext(ctx context.Context, fields logrus.Fields) context.Context {
+	trace, _ := fields[TraceField].(string)
+	spanID, _ := fields[SpanIDField].(string)
+	if len(trace) != 32 || len(spanID) != 16 {
+		return ctx
+	}
+	flags := "00"
+	if sampled, _ := fields[TraceSampledField].(bool); sampled {
+		flags = "01"
+	}
+	return metadata.AppendToOutgoingContext(ctx, TraceparentHeader, fmt.Sprintf("00-%s-%s-%s", trace, spanID, flags))
+}
+
+// serverStream wraps a grpc.ServerStream, overriding its context.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context satisfies the grpc.ServerStream interface.
+func (ss *serverStream) Context() context.Context {
+	return ss.ctx
+}
+
+// clientStream wraps a grpc.ClientStream, calling finish once the stream
+// has ended. Streams without server streaming end with the server's single
+// response.
+type clientStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	once          sync.Once
+	finish        func(error)
+}
+
+// RecvMsg satisfies the grpc.ClientStream interface.
+func (cs *clientStream) RecvMsg(m interface{}) error {
+	err := cs.ClientStream.RecvMsg(m)
+	switch {
+	case errors.Is(err, io.EOF):
+		cs.once.Do(func() { cs.finish(nil) })
+	case err != nil:
+		cs.once.Do(func() { cs.finish(err) })
+	case !cs.serverStreams:
+		cs.once.Do(func() { cs.finish(nil) })
+	}
+	return err
+}
+
+// SendMsg satisfies the grpc.ClientStream interface.
+func (cs *clientStream) SendMsg(m interface{}) error {
+	err := cs.ClientStream.SendMsg(m)
+	if err != nil && !errors.Is(err, io.EOF) {
+		cs.once.Do(func() { cs.finish(err) })
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package sdhook
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	logging "google.golang.org/api/logging/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testServerStream is a grpc.ServerStream with a context.
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context satisfies the grpc.ServerStream interface.
+func (ss *testServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	const method = "/test.Service/Stream"
+	tests := []struct {
+		name     string
+		handler  func() error
+		code     codes.Code
+		severity string
+	}{
+		{"ok", func() error { return nil }, codes.OK, "INFO"},
+		{"not found", func() error { return status.Error(codes.NotFound, "missing") }, codes.NotFound, "WARNING"},
+		{"internal", func() error { return status.Error(codes.Internal, "failed") }, codes.Internal, "ERROR"},
+		{"panic", func() error { panic("boom") }, codes.Internal, "ERROR"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := new(fakeLogging)
+			h := newTestHook(t, f)
+			interceptor := StreamServerInterceptor(newTestLogger(h))
+			var op *Operation
+			err := interceptor(nil, &testServerStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: method}, func(_ interface{}, ss grpc.ServerStream) error {
+				op = OperationFromContext(ss.Context())
+				FromContext(ss.Context()).Info("handling")
+				return test.handler()
+			})
+			if code := status.Code(err); code != test.code {
+				t.Fatalf("expected code %s, got: %s", test.code, code)
+			}
+			if op == nil {
+				t.Fatalf("expected operation to be attached to the stream context")
+			}
+			h.Wait()
+			var entries []*logging.LogEntry
+			for _, req := range f.requests() {
+				entries = append(entries, req.Entries...)
+			}
+			if len(entries) != 3 {
+				t.Fatalf("expected 3 log entries, got: %d", len(entries))
+			}
+			var first, handling, last *logging.LogEntry
+			for _, le := range entries {
+				if le.Operation == nil {
+					t.Fatalf("expected log entry %q to have an operation", le.TextPayload)
+				}
+				if le.Operation.Id != op.ID || le.Operation.Producer != method {
+					t.Errorf("expected operation %s/%s, got: %s/%s", op.ID, method, le.Operation.Id, le.Operation.Producer)
+				}
+				switch {
+				case le.Operation.First && !le.Operation.Last:
+					first = le
+				case le.Operation.Last && !le.Operation.First:
+					last = le
+				case le.TextPayload == "handling":
+					handling = le
+				}
+			}
+			switch {
+			case first == nil || first.TextPayload != "stream started "+method:
+				t.Fatalf("expected first log entry to start the stream, got: %v", first)
+			case handling == nil:
+				t.Fatalf("expected handler log entry to be part of the operation")
+			case last == nil:
+				t.Fatalf("expected last log entry")
+			}
+			if last.Severity != test.severity {
+				t.Errorf("expected last log entry severity %s, got: %s", test.severity, last.Severity)
+			}
+			if !strings.HasPrefix(last.TextPayload, method) && !strings.HasPrefix(last.TextPayload, "panic: boom") {
+				t.Errorf("expected last log entry for the method, got: %q", last.TextPayload)
+			}
+			if test.severity == "ERROR" && (last.SourceLocation == nil || last.SourceLocation.Function != method) {
+				t.Errorf("expected source location of the method, got: %v", last.SourceLocation)
+			}
+		})
+	}
+}
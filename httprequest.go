@@ -0,0 +1,135 @@
+package sdhook
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	logging "google.golang.org/api/logging/v2"
+)
+
+// HTTPRequest wraps a http.Request along with the details of its response.
+//
+// When a logrus field contains a *HTTPRequest, the hook sends it as the log
+// entry's HttpRequest, populating all of the Stackdriver request fields.
+type HTTPRequest struct {
+	// Request is the request that was served.
+	Request *http.Request
+	// Status is the response status code.
+	Status int
+	// Latency is the time taken to serve the request.
+	Latency time.Duration
+	// RequestSize is the size of the request in bytes, including headers and
+	// body. If zero, the request's ContentLength is used.
+	RequestSize int64
+	// ResponseSize is the size of the response in bytes, including headers
+	// and body.
+	ResponseSize int64
+	// ServerIP is the IP address of the server that served the request. If
+	// empty, the local address stored in the request's context by net/http
+	// is used.
+	ServerIP string
+	// CacheLookup indicates whether a cache lookup was attempted.
+	CacheLookup bool
+	// CacheHit indicates whether the response was served from cache.
+	CacheHit bool
+	// CacheValidatedWithOriginServer indicates whether the response was
+	// validated with the origin server before being served from cache.
+	CacheValidatedWithOriginServer bool
+	// CacheFillBytes is the number of bytes inserted into cache.
+	CacheFillBytes int64
+}
+
+// MarshalJSON satisfies the json.Marshaler interface, allowing HTTPRequest
+// fields to be used with other logrus formatters.
+func (req *HTTPRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal((&Hook{}).httpRequest(req))
+}
+
+// httpRequest converts a HTTPRequest into a Stackdriver HttpRequest.
+func (h *Hook) httpRequest(req *HTTPRequest) *logging.HttpRequest {
+	r := &logging.HttpRequest{
+		ResponseSize:                   req.ResponseSize,
+		Status:                         int64(req.Status),
+		ServerIp:                       req.ServerIP,
+		CacheLookup:                    req.CacheLookup,
+		CacheHit:                       req.CacheHit,
+		CacheValidatedWithOriginServer: req.CacheValidatedWithOriginServer,
+		CacheFillBytes:                 req.CacheFillBytes,
+	}
+	if req.Latency > 0 {
+		r.Latency = strconv.FormatFloat(req.Latency.Seconds(), 'f', -1, 64) + "s"
+	}
+	if req.Request == nil {
+		return r
+	}
+	r.Referer = req.Request.Referer()
+	r.RemoteIp = h.clientIP(req.Request)
+	r.RequestMethod = req.Request.Method
+	r.RequestUrl = req.Request.URL.String()
+	r.UserAgent = req.Request.UserAgent()
+	r.Protocol = req.Request.Proto
+	r.RequestSize = req.RequestSize
+	if r.RequestSize == 0 && req.Request.ContentLength > 0 {
+		r.RequestSize = req.Request.ContentLength
+	}
+	if r.ServerIp == "" {
+		if addr, ok := req.Request.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+			if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+				r.ServerIp = host
+			}
+		}
+	}
+	return r
+}
+
+// clientIP returns the IP address of the client that issued the request.
+//
+// When the request was received from a trusted proxy, the X-Forwarded-For
+// header is walked from right to left, and the first address that is not a
+// trusted proxy is returned. Otherwise, the request's RemoteAddr is returned.
+func (h *Hook) clientIP(req *http.Request) string {
+	if len(h.trustedProxies) == 0 || !h.isTrustedProxy(req.RemoteAddr) {
+		return req.RemoteAddr
+	}
+	var addrs []string
+	for _, v := range req.Header.Values("X-Forwarded-For") {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				addrs = append(addrs, s)
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return req.RemoteAddr
+	}
+	for i := len(addrs) - 1; i > 0; i-- {
+		if !h.isTrustedProxy(addrs[i]) {
+			return addrs[i]
+		}
+	}
+	return addrs[0]
+}
+
+// isTrustedProxy returns true if the address (with or without a port) is
+// contained in one of the trusted proxy prefixes.
+func (h *Hook) isTrustedProxy(s string) bool {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range h.trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package sdhook
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name          string
+		proxies       []string
+		remoteAddr    string
+		forwardedFors []string
+		exp           string
+	}{
+		{"no proxies", nil, "10.0.0.1:1234", []string{"1.2.3.4"}, "10.0.0.1:1234"},
+		{"untrusted", []string{"10.0.0.0/8"}, "192.168.0.1:1234", []string{"1.2.3.4"}, "192.168.0.1:1234"},
+		{"trusted", []string{"10.0.0.0/8"}, "10.0.0.1:1234", []string{"1.2.3.4"}, "1.2.3.4"},
+		{"trusted address", []string{"10.0.0.1"}, "10.0.0.1:1234", []string{"1.2.3.4"}, "1.2.3.4"},
+		{"no header", []string{"10.0.0.0/8"}, "10.0.0.1:1234", nil, "10.0.0.1:1234"},
+		{"spoofed", []string{"10.0.0.0/8"}, "10.0.0.1:1234", []string{"6.6.6.6, 1.2.3.4"}, "1.2.3.4"},
+		{"proxy chain", []string{"10.0.0.0/8"}, "10.0.0.1:1234", []string{"1.2.3.4, 10.0.0.2, 10.0.0.3"}, "1.2.3.4"},
+		{"multiple headers", []string{"10.0.0.0/8"}, "10.0.0.1:1234", []string{"6.6.6.6", "1.2.3.4, 10.0.0.2"}, "1.2.3.4"},
+		{"all trusted", []string{"10.0.0.0/8"}, "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"empty values", []string{"10.0.0.0/8"}, "10.0.0.1:1234", []string{" , 1.2.3.4 ,"}, "1.2.3.4"},
+		{"ipv6", []string{"fd00::/8"}, "[fd00::1]:1234", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"ipv4 mapped", []string{"10.0.0.0/8"}, "[::ffff:10.0.0.1]:1234", []string{"1.2.3.4"}, "1.2.3.4"},
+		{"invalid remote", []string{"10.0.0.0/8"}, "invalid", []string{"1.2.3.4"}, "invalid"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := new(Hook)
+			if err := TrustedProxies(test.proxies...)(h); err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			req.RemoteAddr = test.remoteAddr
+			for _, v := range test.forwardedFors {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if ip := h.clientIP(req); ip != test.exp {
+				t.Errorf("expected %q, got: %q", test.exp, ip)
+			}
+		})
+	}
+}
+
+func TestTrustedProxiesInvalid(t *testing.T) {
+	tests := []string{"invalid", "10.0.0.0/33", "10.0.0.256"}
+	for _, test := range tests {
+		t.Run(test, func(t *testing.T) {
+			if err := TrustedProxies(test)(new(Hook)); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
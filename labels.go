@@ -0,0 +1,156 @@
+package sdhook
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Default Stackdriver label limits.
+//
+// See: https://cloud.google.com/logging/quotas#log-limits
+const (
+	// DefaultMaxLabels is the default maximum number of labels per log
+	// entry, including the common labels set with the Labels option.
+	DefaultMaxLabels = 64
+	// DefaultMaxLabelKeySize is the default maximum size of a label key in
+	// bytes.
+	DefaultMaxLabelKeySize = 512
+	// DefaultMaxLabelValueSize is the default maximum size of a label value
+	// in bytes.
+	DefaultMaxLabelValueSize = 64 * 1024
+)
+
+// truncatedMarker is appended to truncated label keys, values and payloads.
+const truncatedMarker = "...(truncated)"
+
+// LabelStats are the counts of labels that were modified or dropped by the
+// hook to satisfy the label limits.
+type LabelStats struct {
+	// KeysRewritten is the number of label keys containing invalid
+	// characters that were rewritten.
+	KeysRewritten uint64
+	// KeysTruncated is the number of label keys that were truncated.
+	KeysTruncated uint64
+	// ValuesTruncated is the number of label values that were truncated.
+	ValuesTruncated uint64
+	// Overflowed is the number of labels exceeding the maximum number of
+	// labels that were moved to the log entry's payload.
+	Overflowed uint64
+}
+
+// labelStats holds the label counters.
+type labelStats struct {
+	keysRewritten   atomic.Uint64
+	keysTruncated   atomic.Uint64
+	valuesTruncated atomic.Uint64
+	overflowed      atomic.Uint64
+}
+
+// LabelStats returns the counts of labels that were modified or dropped by
+// the hook to satisfy the label limits.
+func (h *Hook) LabelStats() LabelStats {
+	return LabelStats{
+		KeysRewritten:   h.labelStats.keysRewritten.Load(),
+		KeysTruncated:   h.labelStats.keysTruncated.Load(),
+		ValuesTruncated: h.labelStats.valuesTruncated.Load(),
+		Overflowed:      h.labelStats.overflowed.Load(),
+	}
+}
+
+// sanitizeLabels normalizes the label keys and values of the record to
+// satisfy the label limits. Labels exceeding the maximum number of labels
+// are moved to the record's overflow, in key order.
+func (h *Hook) sanitizeLabels(r *record) {
+	labels := make(map[string]string, len(r.labels))
+	keys := make([]string, 0, len(r.labels))
+	for k := range r.labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	n := h.maxLabels - len(h.labels)
+	for _, k := range keys {
+		v := r.labels[k]
+		key := h.sanitizeLabelKey(k)
+		if len(v) > h.maxLabelValueSize {
+			v = truncate(v, h.maxLabelValueSize, byteSize)
+			h.labelStats.valuesTruncated.Add(1)
+		}
+		// move to overflow when at the limit, or when the sanitized key
+		// collides with another label
+		if _, ok := labels[key]; ok || len(labels) >= n {
+			if r.overflow == nil {
+				r.overflow = make(map[string]string)
+			}
+			r.overflow[k] = v
+			h.labelStats.overflowed.Add(1)
+			continue
+		}
+		labels[key] = v
+	}
+	r.labels = labels
+}
+
+// sanitizeLabelKey rewrites the invalid characters of a label key and
+// truncates it to the maximum label key size.
+func (h *Hook) sanitizeLabelKey(key string) string {
+	if key == "" {
+		h.labelStats.keysRewritten.Add(1)
+		return "_"
+	}
+	s := strings.Map(func(r rune) rune {
+		if 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || strings.ContainsRune("_-./", r) {
+			return r
+		}
+		return '_'
+	}, key)
+	if s != key {
+		h.labelStats.keysRewritten.Add(1)
+	}
+	if len(s) > h.maxLabelKeySize {
+		s = truncate(s, h.maxLabelKeySize, byteSize)
+		h.labelStats.keysTruncated.Add(1)
+	}
+	return s
+}
+
+// runeSize returns the size of a rune of s, given its UTF-8 encoded width.
+type runeSize func(r rune, width int) int
+
+// byteSize is the runeSize of a rune in bytes.
+func byteSize(_ rune, width int) int {
+	return width
+}
+
+// jsonSize is the runeSize of a rune when encoded in a JSON string.
+func jsonSize(r rune, _ int) int {
+	return jsonRuneLen(r)
+}
+
+// truncate truncates s so that its size, including the truncated marker, is
+// at most n bytes, without splitting a UTF-8 encoded rune.
+func truncate(s string, n int, size runeSize) string {
+	var l int
+	for i := 0; i < len(s); {
+		r, w := utf8.DecodeRuneInString(s[i:])
+		l, i = l+size(r, w), i+w
+	}
+	switch {
+	case l <= n:
+		return s
+	case n <= 0:
+		return ""
+	case n <= len(truncatedMarker):
+		return truncatedMarker[:n]
+	}
+	n, l = n-len(truncatedMarker), 0
+	for i := 0; i < len(s); {
+		r, w := utf8.DecodeRuneInString(s[i:])
+		if l += size(r, w); l > n {
+			return s[:i] + truncatedMarker
+		}
+		i += w
+	}
+	return s + truncatedMarker
+}
@@ -0,0 +1,205 @@
+package sdhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Default call timeouts.
+const (
+	// DefaultWriteTimeout is the default timeout of each write to the logging
+	// API.
+	DefaultWriteTimeout = 30 * time.Second
+	// DefaultReportTimeout is the default timeout of each report to the error
+	// reporting API.
+	DefaultReportTimeout = 30 * time.Second
+)
+
+// ErrClosed is the error returned when closing a hook that is already
+// closed.
+var ErrClosed = errors.New("hook is closed")
+
+// callContext returns the context for an API call, derived from the hook's
+// context with the timeout, if any.
+func (h *Hook) callContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(h.ctx, timeout)
+	}
+	return context.WithCancel(h.ctx)
+}
+
+// sleep sleeps for d, returning false if the hook's context was cancelled.
+func (h *Hook) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-h.ctx.Done():
+		return false
+	}
+}
+
+// Shutdown delivers pending log entries, and then cancels the hook's
+// context, closing the logging agent client and sinks. If ctx is done before
+// the pending log entries are delivered, the hook's context is cancelled,
+// aborting in-flight calls, and ctx's error is returned. Shutdown does not
+// wait for the logging agent client to close once ctx is done.
+//
+// Log entries fired after Shutdown are dropped.
+func (h *Hook) Shutdown(ctx context.Context) error {
+	if !h.closed.CompareAndSwap(false, true) {
+		return ErrClosed
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.Wait()
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		h.cancel()
+		<-done
+	}
+	h.cancel()
+	if h.agentClient != nil {
+		// closing the client delivers its buffered messages, retrying while
+		// the logging agent is unavailable
+		closed := make(chan error, 1)
+		go func() {
+			closed <- h.agentClient.Close()
+		}()
+		select {
+		case e := <-closed:
+			if e != nil && err == nil {
+				err = e
+			}
+		case <-ctx.Done():
+			if err == nil {
+				err = ctx.Err()
+			}
+		}
+	}
+	for _, s := range h.sinks {
+		if e := closeSink(s.sink); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Flush delivers the log entries and error reports pending when Flush is
+// called, including pending batches and follow-up reports of repeated
+// errors, and waits for them to be delivered until ctx is done. Unlike Wait,
+// Flush does not wait for log entries fired after it was called, and can be
+// used while logging continues.
+func (h *Hook) Flush(ctx context.Context) error {
+	h.flushErrorReports()
+	if err := h.pending.wait(ctx); err != nil {
+		return err
+	}
+	// the log entries delivered so far were added to the pending batches
+	if h.batcher != nil {
+		return h.batcher.flushContext(ctx)
+	}
+	return nil
+}
+
+// Close delivers pending log entries and closes the hook (see Shutdown).
+// Use WriteTimeout and ReportTimeout to bound the time taken by each call.
+func (h *Hook) Close() error {
+	return h.Shutdown(context.Background())
+}
+
+// closeSink closes the sink, if it is closable.
+func closeSink(sink Sink) error {
+	switch s := sink.(type) {
+	case *failoverSink:
+		var err error
+		for _, sink := range s.sinks {
+			if e := closeSink(sink); e != nil && err == nil {
+				err = e
+			}
+		}
+		return err
+	case io.Closer:
+		return s.Close()
+	}
+	return nil
+}
+
+// tracker tracks in-flight work, so that the work started before a call to
+// wait can be waited for without waiting for the work started after it.
+type tracker struct {
+	mu sync.Mutex
+	// cur is the epoch of the work started since the last call to wait.
+	cur *epoch
+	// open are the previous epochs with in-flight work.
+	open []*epoch
+}
+
+// epoch is the work started between two calls to wait.
+type epoch struct {
+	n      int
+	sealed bool
+	done   chan struct{}
+}
+
+// add tracks new work, returning its epoch, which must be passed to finish
+// when the work is done.
+func (t *tracker) add() *epoch {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cur == nil {
+		t.cur = &epoch{done: make(chan struct{})}
+	}
+	t.cur.n++
+	return t.cur
+}
+
+// finish marks work of the epoch as done.
+func (t *tracker) finish(e *epoch) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e.n--
+	if e.n == 0 && e.sealed {
+		t.close(e)
+	}
+}
+
+// close closes the sealed epoch, removing it from the open epochs.
+func (t *tracker) close(e *epoch) {
+	close(e.done)
+	for i, o := range t.open {
+		if o == e {
+			t.open = append(t.open[:i], t.open[i+1:]...)
+			break
+		}
+	}
+}
+
+// wait waits until the work started before the call is done, or until ctx
+// is done.
+func (t *tracker) wait(ctx context.Context) error {
+	t.mu.Lock()
+	if e := t.cur; e != nil {
+		t.cur, e.sealed = nil, true
+		t.open = append(t.open, e)
+	}
+	open := append([]*epoch(nil), t.open...)
+	t.mu.Unlock()
+	for _, e := range open {
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package sdhook
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HTTPRequestField is the logrus field name used by Middleware for the
+// request log entry's HTTPRequest.
+const HTTPRequestField = "httpRequest"
+
+// contextKey is the context key type.
+type contextKey int
+
+// context keys.
+const (
+	entryKey contextKey = iota
+	operationKey
+)
+
+// NewContext returns a copy of the parent context with the logrus entry
+// attached.
+func NewContext(parent context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(parent, entryKey, entry)
+}
+
+// FromContext returns the logrus entry attached to the context. If no entry
+// is attached, a new entry for the logrus standard logger is returned.
+//
+// Within a handler wrapped with Middleware, the returned entry carries the
+// request's trace fields, causing application logs to be grouped with the
+// request log entry in the Logs Explorer.
+func FromContext(ctx context.Context) *logrus.Entry {
+	if entry, ok := ctx.Value(entryKey).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+// Middleware returns net/http middleware that writes a request log entry to
+// the logger for each request served, including a complete HTTPRequest and
+// the trace propagated in the request headers.
+//
+// A request-scoped logrus entry carrying the trace fields is attached to the
+// request's context, and can be retrieved with FromContext.
+//
+// The message of the request log entry is the request's method and path,
+// without the query, which is only sent in the HTTPRequest's URL so that it
+// can be redacted. Requests with a 5xx response status are logged at the
+// warning level, all others at the info level.
+func Middleware(logger logrus.FieldLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			entry := logger.WithFields(TraceFields(req))
+			var body *countingReader
+			if req.Body != nil && req.Body != http.NoBody {
+				body = &countingReader{ReadCloser: req.Body}
+				req.Body = body
+			}
+			rw := &responseWriter{ResponseWriter: w}
+			next.ServeHTTP(rw, req.WithContext(NewContext(req.Context(), entry)))
+			if rw.status == 0 {
+				rw.status = http.StatusOK
+			}
+			r := &HTTPRequest{
+				Request:      req,
+				Status:       rw.status,
+				Latency:      time.Since(start),
+				ResponseSize: rw.size,
+			}
+			if body != nil {
+				r.RequestSize = body.n
+			}
+			level := logrus.InfoLevel
+			if rw.status >= http.StatusInternalServerError {
+				level = logrus.WarnLevel
+			}
+			entry.WithField(HTTPRequestField, r).Log(level, req.Method+" "+req.URL.Path)
+		})
+	}
+}
+
+// responseWriter wraps a http.ResponseWriter, capturing the status and the
+// number of bytes written.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+// WriteHeader satisfies the http.ResponseWriter interface.
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write satisfies the http.ResponseWriter interface.
+func (w *responseWriter) Write(buf []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(buf)
+	w.size += int64(n)
+	return n, err
+}
+
+// Flush satisfies the http.Flusher interface.
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Hijack satisfies the http.Hijacker interface.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		if w.status == 0 {
+			w.status = http.StatusSwitchingProtocols
+		}
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("response writer does not support hijacking")
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, for use with
+// http.ResponseController.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// countingReader wraps a io.ReadCloser, counting the number of bytes read.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+// Read satisfies the io.Reader interface.
+func (r *countingReader) Read(buf []byte) (int, error) {
+	n, err := r.ReadCloser.Read(buf)
+	r.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,101 @@
+package sdhook
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	logging "google.golang.org/api/logging/v2"
+)
+
+// Operation groups the log entries of a long-running operation, such as a
+// batch job or a streaming call, in the Logs Explorer.
+//
+// The first log entry written for the operation is automatically marked as
+// the first entry of the operation, and the entry created by End is marked
+// as the last.
+type Operation struct {
+	// ID is the operation identifier. Log entries with the same ID and
+	// Producer are grouped together.
+	ID string
+	// Producer is an arbitrary identifier of the operation's producer, such
+	// as "github.com/kenshaw/sdhook" or a gRPC method name.
+	Producer string
+
+	mu      sync.Mutex
+	started bool
+}
+
+// NewOperation creates a new operation. If id is empty, a random id is
+// generated.
+func NewOperation(id, producer string) *Operation {
+	if id == "" {
+		id = randomID(16)
+	}
+	return &Operation{
+		ID:       id,
+		Producer: producer,
+	}
+}
+
+// Begin returns a logrus entry for the logger marked as the first entry of
+// the operation.
+func (op *Operation) Begin(logger logrus.FieldLogger) *logrus.Entry {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.started = true
+	return logger.WithField(OperationField, op.entryOperation(true, false))
+}
+
+// Entry returns a logrus entry for the logger that is part of the
+// operation. If no entry has yet been written for the operation, the entry
+// is marked as the first entry of the operation when it is fired.
+func (op *Operation) Entry(logger logrus.FieldLogger) *logrus.Entry {
+	return logger.WithField(OperationField, op)
+}
+
+// End returns a logrus entry for the logger marked as the last entry of the
+// operation.
+func (op *Operation) End(logger logrus.FieldLogger) *logrus.Entry {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	first := !op.started
+	op.started = true
+	return logger.WithField(OperationField, op.entryOperation(first, true))
+}
+
+// next returns the log entry operation for the next entry written for the
+// operation.
+func (op *Operation) next() *logging.LogEntryOperation {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	first := !op.started
+	op.started = true
+	return op.entryOperation(first, false)
+}
+
+// entryOperation returns the log entry operation with the first and last
+// markers.
+func (op *Operation) entryOperation(first, last bool) *logging.LogEntryOperation {
+	return &logging.LogEntryOperation{
+		Id:       op.ID,
+		Producer: op.Producer,
+		First:    first,
+		Last:     last,
+	}
+}
+
+// WithOperation returns a copy of the parent context with the operation
+// attached. The logrus entry attached to the context (see FromContext) is
+// replaced with an entry that is part of the operation.
+func WithOperation(parent context.Context, op *Operation) context.Context {
+	ctx := context.WithValue(parent, operationKey, op)
+	return NewContext(ctx, op.Entry(FromContext(parent)))
+}
+
+// OperationFromContext returns the operation attached to the context, or nil
+// if no operation is attached.
+func OperationFromContext(ctx context.Context) *Operation {
+	op, _ := ctx.Value(operationKey).(*Operation)
+	return op
+}
@@ -4,7 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/compute/metadata"
 	"github.com/fluent/fluent-logger-golang/fluent"
@@ -22,19 +27,19 @@ type Option func(*Hook) error
 // Levels is an option that sets the logrus levels that the StackdriverHook
 // will create log entries for.
 func Levels(levels ...logrus.Level) Option {
-	return func(h *Hook) error {
+	return setting(func(h *Hook) error {
 		h.levels = levels
 		return nil
-	}
+	})
 }
 
 // ProjectID is an option that sets the project ID which is needed for the log
 // name.
 func ProjectID(projectID string) Option {
-	return func(h *Hook) error {
+	return setting(func(h *Hook) error {
 		h.projectID = projectID
 		return nil
-	}
+	})
 }
 
 // EntriesService is an option that sets the Google API entry service to use
@@ -111,12 +116,141 @@ func Resource(typ ResType, labels map[string]string) Option {
 // Log names are specified as "projects/{projectID}/logs/{logName}"
 // if the projectID is set. Otherwise, it's just "{logName}"
 func LogName(name string) Option {
+	return setting(func(h *Hook) error {
+		h.logName = logPath(h.projectID, name)
+		return nil
+	})
+}
+
+// LogNameField is an option that sets the logrus field containing the name
+// of the log to send each log entry to, such as "log" for entries logged
+// with logger.WithField("log", "audit"). The field is not sent as a label.
+//
+// Log entries routed by field take precedence over log name rules, and are
+// not sent to the error reporting log.
+func LogNameField(field string) Option {
+	return setting(func(h *Hook) error {
+		h.logNameField = field
+		return nil
+	})
+}
+
+// LogNameRules is an option that adds log name routing rules, choosing the
+// log to send each log entry to. Rules are evaluated in order, and the first
+// log name returned is used. When no rule applies, the log entry is sent to
+// the log set with LogName.
+//
+// Routed log entries are not sent to the error reporting log.
+func LogNameRules(rules ...LogNameRule) Option {
+	return setting(func(h *Hook) error {
+		h.logNameRules = append(h.logNameRules, rules...)
+		return nil
+	})
+}
+
+// Batching is an option that batches log entries written to the logging
+// API, with a separate batch for each destination log. A batch is written
+// when it reaches size log entries, or interval after its first log entry
+// was added.
+//
+// Pending batches are written by Wait.
+func Batching(size int, interval time.Duration) Option {
+	return func(h *Hook) error {
+		if size < 1 || interval <= 0 {
+			return errors.New("invalid batching")
+		}
+		h.batcher = newBatcher(size, interval, h.write)
+		return nil
+	}
+}
+
+// Routes is an option that adds routing rules, choosing the project,
+// monitored resource and credentials for each log entry. Rules are evaluated
+// in order, and the first route returned is used. When no rule applies, the
+// hook's project, monitored resource and services are used.
+//
+// Routes only apply to the logging API and the error reporting API, and not
+// to the logging agent.
+func Routes(rules ...RouteRule) Option {
+	return setting(func(h *Hook) error {
+		h.routeRules = append(h.routeRules, rules...)
+		return nil
+	})
+}
+
+// RouteCredentials is an option that sets the function resolving the
+// credentials keys of routes to HTTP clients. The logging and error
+// reporting services for each credentials key are created on first use, and
+// cached for the lifetime of the hook.
+func RouteCredentials(f CredentialsFunc) Option {
+	return func(h *Hook) error {
+		h.credentials = f
+		return nil
+	}
+}
+
+// AddSink is an option that adds a sink receiving the log entries at the
+// levels, or at all levels when no levels are specified. Log entries are
+// delivered to each sink independently, and delivery errors of a sink do not
+// affect the other sinks.
+//
+// When no sinks are added, log entries are delivered to the logging agent
+// when GoogleLoggingAgent is set, and otherwise to the logging API.
+func AddSink(sink Sink, levels ...logrus.Level) Option {
+	return func(h *Hook) error {
+		if sink == nil {
+			return errors.New("invalid sink")
+		}
+		h.sinks = append(h.sinks, sinkFilter{
+			sink:   sink,
+			levels: levels,
+		})
+		return nil
+	}
+}
+
+// WriteTimeout is an option that sets the timeout of each write to the
+// logging API, defaulting to DefaultWriteTimeout. A timeout of 0 disables
+// the timeout.
+func WriteTimeout(timeout time.Duration) Option {
+	return func(h *Hook) error {
+		if timeout < 0 {
+			return errors.New("invalid write timeout")
+		}
+		h.writeTimeout = timeout
+		return nil
+	}
+}
+
+// ReportTimeout is an option that sets the timeout of each report to the
+// error reporting API, defaulting to DefaultReportTimeout. A timeout of 0
+// disables the timeout.
+func ReportTimeout(timeout time.Duration) Option {
+	return func(h *Hook) error {
+		if timeout < 0 {
+			return errors.New("invalid report timeout")
+		}
+		h.reportTimeout = timeout
+		return nil
+	}
+}
+
+// CircuitBreaker is an option that wraps writes to the logging API and
+// reports to the error reporting API with circuit breakers. A circuit
+// breaker opens after threshold consecutive failures, short-circuiting
+// further calls with ErrCircuitOpen, so that log entries are passed to the
+// next sink of a failover chain or dropped without waiting for the service.
+// After the cooldown, a single probe call is let through, closing the circuit
+// breaker when it succeeds.
+//
+// Log entries rejected by the logging API do not count as failures.
+func CircuitBreaker(threshold int, cooldown time.Duration) Option {
 	return func(h *Hook) error {
-		if h.projectID == "" {
-			h.logName = name
-		} else {
-			h.logName = fmt.Sprintf("projects/%s/logs/%s", h.projectID, name)
+		if threshold < 1 || cooldown <= 0 {
+			return errors.New("invalid circuit breaker")
 		}
+		h.writeBreaker = newBreaker(threshold, cooldown)
+		h.reportBreaker = newBreaker(threshold, cooldown)
 		return nil
 	}
 }
@@ -125,26 +259,64 @@ func LogName(name string) Option {
 // with each error message for error reporting.
 // Only used when ErrorReportingService has been set.
 func ErrorReportingLogName(name string) Option {
-	return func(h *Hook) error {
+	return setting(func(h *Hook) error {
 		h.errorReportingLogName = name
 		return nil
-	}
+	})
 }
 
 // Labels is an option that sets the labels to send with each log entry.
 func Labels(labels map[string]string) Option {
-	return func(h *Hook) error {
+	return setting(func(h *Hook) error {
 		h.labels = labels
 		return nil
-	}
+	})
 }
 
 // PartialSuccess is an option that toggles whether or not to write partial log
 // entries.
 func PartialSuccess(enabled bool) Option {
-	return func(h *Hook) error {
+	return setting(func(h *Hook) error {
 		h.partialSuccess = enabled
 		return nil
+	})
+}
+
+// PartialErrorHandler is an option that sets a handler called for each log
+// entry rejected by a write, after any retries. When not set, rejected log
+// entries are passed to the error handler.
+func PartialErrorHandler(f func(*EntryError)) Option {
+	return func(h *Hook) error {
+		h.partialErrorHandler = f
+		return nil
+	}
+}
+
+// PartialRetries is an option that sets the number of times log entries
+// rejected with a retryable error (such as codes.Unavailable) are written
+// again. Only the rejected log entries are written again.
+//
+// Defaults to DefaultPartialRetries.
+func PartialRetries(n int) Option {
+	return setting(func(h *Hook) error {
+		if n < 0 {
+			return errors.New("invalid partial retries")
+		}
+		h.partialRetries = n
+		return nil
+	})
+}
+
+// ErrorHandler is an option that sets a handler called for errors
+// encountered while delivering log entries. When not set, errors are logged
+// with the standard library's log package.
+//
+// The handler must not log to a logger using the hook, as doing so can
+// cause an endless loop of delivery errors.
+func ErrorHandler(f func(*DeliveryError)) Option {
+	return func(h *Hook) error {
+		h.errorHandler = f
+		return nil
 	}
 }
 
@@ -153,9 +325,31 @@ func PartialSuccess(enabled bool) Option {
 // See:
 // https://cloud.google.com/error-reporting/docs/formatting-error-messages
 func ErrorReportingService(service string) Option {
-	return func(h *Hook) error {
+	return setting(func(h *Hook) error {
 		h.errorReportingServiceName = service
 		return nil
+	})
+}
+
+// ErrorDeduplication is an option that collapses repeated errors reported to
+// the error reporting service. Errors are identified by their message, stack
+// trace and report location. The first occurrence of an error is reported
+// immediately, and repeats within the window are counted and reported as a
+// single follow-up report annotated with the number of repeats when the
+// window ends.
+//
+// At most size errors are tracked, defaulting to
+// DefaultErrorDeduplicationSize when size is 0.
+func ErrorDeduplication(window time.Duration, size int) Option {
+	return func(h *Hook) error {
+		if window <= 0 || size < 0 {
+			return errors.New("invalid error deduplication")
+		}
+		if size == 0 {
+			size = DefaultErrorDeduplicationSize
+		}
+		h.errorDedup = newErrorDedup(window, size, h.dueErrorReports)
+		return nil
 	}
 }
 
@@ -165,10 +359,139 @@ func ErrorReportingService(service string) Option {
 // See:
 // https://cloud.google.com/error-reporting/docs/formatting-error-messages
 func UseLoggingServiceForErrors(enabled bool) Option {
-	return func(h *Hook) error {
+	return setting(func(h *Hook) error {
 		h.useLoggingServiceForErrors = enabled
 		return nil
-	}
+	})
+}
+
+// LabelLimits is an option that sets the maximum number of labels per log
+// entry (including the common labels set with the Labels option), and the
+// maximum size in bytes of label keys and values. Label keys and values
+// exceeding the maximum size are truncated, and labels exceeding the maximum
+// number of labels are sent in the log entry's payload.
+//
+// By default, the Stackdriver limits are used. See DefaultMaxLabels,
+// DefaultMaxLabelKeySize and DefaultMaxLabelValueSize.
+func LabelLimits(maxLabels, maxKeySize, maxValueSize int) Option {
+	return setting(func(h *Hook) error {
+		if maxLabels < 0 || maxKeySize <= len(truncatedMarker) || maxValueSize <= len(truncatedMarker) {
+			return errors.New("invalid label limits")
+		}
+		h.maxLabels = maxLabels
+		h.maxLabelKeySize = maxKeySize
+		h.maxLabelValueSize = maxValueSize
+		return nil
+	})
+}
+
+// MaxEntrySize is an option that sets the maximum size in bytes of a log
+// entry, and the handling of log entries exceeding it. A size of 0 disables
+// the size check.
+//
+// By default, log entries exceeding DefaultMaxEntrySize are truncated.
+// OversizeSplit only applies to log entries written to the logging API, log
+// entries sent to the logging agent are truncated.
+func MaxEntrySize(size int, mode OversizeMode) Option {
+	return setting(func(h *Hook) error {
+		if size < 0 {
+			return errors.New("invalid max entry size")
+		}
+		h.maxEntrySize = size
+		h.oversizeMode = mode
+		return nil
+	})
+}
+
+// TrustedProxies is an option that sets the proxies (as IP addresses or CIDR
+// prefixes) that are trusted to set the X-Forwarded-For header. When a
+// request is received from a trusted proxy, the client IP sent with the log
+// entry is taken from the X-Forwarded-For header instead of the request's
+// RemoteAddr.
+func TrustedProxies(proxies ...string) Option {
+	return setting(func(h *Hook) error {
+		for _, s := range proxies {
+			if !strings.Contains(s, "/") {
+				addr, err := netip.ParseAddr(s)
+				if err != nil {
+					return fmt.Errorf("invalid trusted proxy %q: %v", s, err)
+				}
+				h.trustedProxies = append(h.trustedProxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+				continue
+			}
+			p, err := netip.ParsePrefix(s)
+			if err != nil {
+				return fmt.Errorf("invalid trusted proxy %q: %v", s, err)
+			}
+			h.trustedProxies = append(h.trustedProxies, p.Masked())
+		}
+		return nil
+	})
+}
+
+// Redact is an option that adds rules for redacting log entry fields and
+// HTTP request URLs before they are sent. Rules are applied in order.
+func Redact(rules ...RedactRule) Option {
+	return setting(func(h *Hook) error {
+		for _, rule := range rules {
+			if err := rule.validate(); err != nil {
+				return err
+			}
+		}
+		h.redactRules = append(h.redactRules, rules...)
+		return nil
+	})
+}
+
+// Sampling is an option that samples log entries per level and message. In
+// each interval, the first log entries with the same level and message are
+// sent, and thereafter only every nth log entry. When thereafter is 0, all
+// log entries after the first are suppressed.
+//
+// A summary log entry with the number of suppressed log entries is sent at
+// the warning level once per interval, when log entries were suppressed.
+// The number of distinct levels and messages counted is bounded, so that
+// log entries with different messages may occasionally be counted
+// together.
+func Sampling(interval time.Duration, first, thereafter int) Option {
+	return setting(func(h *Hook) error {
+		if interval <= 0 || first < 0 || thereafter < 0 {
+			return errors.New("invalid sampling")
+		}
+		h.sampler = &sampler{
+			interval:   interval,
+			first:      uint64(first),
+			thereafter: uint64(thereafter),
+		}
+		h.summaryInterval = interval
+		return nil
+	})
+}
+
+// RateLimit is an option that limits the rate of log entries per second at
+// each of the levels, allowing bursts of up to burst log entries. If no
+// levels are specified, the rate limit applies to all levels, with a
+// separate limit per level.
+//
+// A summary log entry with the number of suppressed log entries is sent at
+// the warning level once per sampling interval, or DefaultSummaryInterval
+// when sampling is not set, when log entries were suppressed.
+func RateLimit(rate float64, burst int, levels ...logrus.Level) Option {
+	return setting(func(h *Hook) error {
+		if rate <= 0 || burst < 1 {
+			return errors.New("invalid rate limit")
+		}
+		if len(levels) == 0 {
+			levels = logrus.AllLevels
+		}
+		if h.rateLimits == nil {
+			h.rateLimits = make(map[logrus.Level]*tokenBucket)
+		}
+		for _, l := range levels {
+			h.rateLimits[l] = newTokenBucket(rate, burst)
+		}
+		return nil
+	})
 }
 
 // requiredScopes are the oauth2 scopes required for stackdriver logging.
@@ -184,15 +507,10 @@ var requiredScopes = []string{
 // console: https://console.cloud.google.com/iam-admin/serviceaccounts/
 func GoogleServiceAccountCredentialsJSON(buf []byte) Option {
 	return func(h *Hook) error {
-		// load credentials
-		gsa, err := gserviceaccount.FromJSON(buf)
+		gsa, client, err := serviceAccountClient(buf)
 		if err != nil {
 			return err
 		}
-		// check project id
-		if gsa.ProjectID == "" {
-			return errors.New("google service account credentials missing project_id")
-		}
 		// set project id
 		if err = ProjectID(gsa.ProjectID)(h); err != nil {
 			return err
@@ -204,20 +522,35 @@ func GoogleServiceAccountCredentialsJSON(buf []byte) Option {
 		if err != nil {
 			return err
 		}
-		// create token source
-		ts, err := gsa.TokenSource(nil, requiredScopes...)
-		if err != nil {
-			return err
-		}
 		// set client
-		return HTTPClient(&http.Client{
-			Transport: &oauth2.Transport{
-				Source: oauth2.ReuseTokenSource(nil, ts),
-			},
-		})(h)
+		return HTTPClient(client)(h)
 	}
 }
 
+// serviceAccountClient loads the Google Service Account credentials, and
+// creates a http.Client using them.
+func serviceAccountClient(buf []byte) (*gserviceaccount.GServiceAccount, *http.Client, error) {
+	// load credentials
+	gsa, err := gserviceaccount.FromJSON(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	// check project id
+	if gsa.ProjectID == "" {
+		return nil, nil, errors.New("google service account credentials missing project_id")
+	}
+	// create token source
+	ts, err := gsa.TokenSource(nil, requiredScopes...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return gsa, &http.Client{
+		Transport: &oauth2.Transport{
+			Source: oauth2.ReuseTokenSource(nil, ts),
+		},
+	}, nil
+}
+
 // GoogleServiceAccountCredentialsFile is an option that loads Google Service
 // Account credentials for use with the StackdriverHook from the specified
 // file.
@@ -240,22 +573,11 @@ func GoogleServiceAccountCredentialsFile(path string) Option {
 // associated with the GCE instance will be used.
 func GoogleComputeCredentials(serviceAccount string) Option {
 	return func(h *Hook) error {
-		// get compute metadata scopes associated with the service account
-		scopes, err := metadata.Scopes(serviceAccount)
-		if err != nil {
-			return err
-		}
 		// check if all the necessary scopes are provided
-		for _, s := range requiredScopes {
-			if !sliceContains(scopes, s) {
-				// NOTE: if you are seeing this error, you probably need to
-				// recreate your compute instance with the correct scope
-				//
-				// as of August 2016, there is not a way to add a scope to an
-				// existing compute instance
-				return fmt.Errorf("missing required scope %s in compute metadata", s)
-			}
+		if err := checkScopes(serviceAccount); err != nil {
+			return err
 		}
+		h.computeCredentials, h.computeServiceAccount = true, serviceAccount
 		return HTTPClient(&http.Client{
 			Transport: &oauth2.Transport{
 				Source: google.ComputeTokenSource(serviceAccount),
@@ -265,24 +587,64 @@ func GoogleComputeCredentials(serviceAccount string) Option {
 }
 
 func GoogleLoggingAgent() Option {
+	return googleLoggingAgent("", 0)
+}
+
+// googleLoggingAgent sets the logging agent client for the agent at the
+// host and port, defaulting to 127.0.0.1:24224.
+func googleLoggingAgent(host string, port int) Option {
 	return func(h *Hook) error {
+		if host == "" {
+			host = "127.0.0.1"
+		}
+		if port == 0 {
+			port = 24224
+		}
 		// set agent client. It expects that the forward input fluentd plugin
 		// is properly configured by the Google logging agent, which is by default.
 		// See more at:
 		// https://cloud.google.com/error-reporting/docs/setup/ec2
+		//
+		// Messages are delivered asynchronously, with delivery failures
+		// reported to agentResult.
 		var err error
 		h.agentClient, err = fluent.New(
 			fluent.Config{
-				Async: true,
+				FluentHost:          host,
+				FluentPort:          port,
+				Async:               true,
+				AsyncResultCallback: h.agentResult,
+				MaxRetry:            agentMaxRetry,
 			},
 		)
 		if err != nil {
-			return fmt.Errorf("could not find fluentd agent on 127.0.0.1:24224: %v", err)
+			return fmt.Errorf("could not find fluentd agent on %s:%d: %v", host, port, err)
 		}
+		h.agentAddr = net.JoinHostPort(host, strconv.Itoa(port))
 		return nil
 	}
 }
 
+// checkScopes checks that the compute metadata scopes of the service account
+// include the required scopes.
+func checkScopes(serviceAccount string) error {
+	scopes, err := metadata.Scopes(serviceAccount)
+	if err != nil {
+		return err
+	}
+	for _, s := range requiredScopes {
+		if !sliceContains(scopes, s) {
+			// NOTE: if you are seeing this error, you probably need to
+			// recreate your compute instance with the correct scope
+			//
+			// as of August 2016, there is not a way to add a scope to an
+			// existing compute instance
+			return &PermissionError{Scope: s}
+		}
+	}
+	return nil
+}
+
 // sliceContains returns true if haystack contains needle.
 func sliceContains(haystack []string, needle string) bool {
 	for _, s := range haystack {
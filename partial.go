@@ -0,0 +1,176 @@
+package sdhook
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+	logging "google.golang.org/api/logging/v2"
+	"google.golang.org/grpc/codes"
+)
+
+// DefaultPartialRetries is the default number of times log entries rejected
+// with a retryable error are written again.
+const DefaultPartialRetries = 2
+
+// partialErrorsType is the type of the error details listing the log
+// entries rejected by a write.
+const partialErrorsType = "type.googleapis.com/google.logging.v2.WriteLogEntriesPartialErrors"
+
+// EntryError is the error for a log entry rejected by a write.
+type EntryError struct {
+	// Entry is the logrus entry.
+	Entry *logrus.Entry
+	// LogEntry is the rejected log entry.
+	LogEntry *logging.LogEntry
+	// Code is the rejection's status code.
+	Code codes.Code
+	// Message is the rejection's message.
+	Message string
+	// Attempts is the number of times the log entry was written.
+	Attempts int
+}
+
+// Error satisfies the error interface.
+func (err *EntryError) Error() string {
+	return fmt.Sprintf("log entry rejected (%s): %s", err.Code, err.Message)
+}
+
+// writeEntries writes the log entries with the service, where records[i] is the record of
+// req.Entries[i], returning the number of write attempts.
+//
+// When the write fails with per-entry errors, only the rejected entries
+// with a retryable error are written again, along with any entries that
+// were not written because partial success is disabled. Rejected entries
+// that are not retried are passed to the partial error handler. Other write
+// errors are returned.
+func (h *Hook) writeEntries(service *logging.EntriesService, req *logging.WriteLogEntriesRequest, records []*record) (int, error) {
+	h.mu.RLock()
+	partialRetries := h.partialRetries
+	h.mu.RUnlock()
+	for attempt := 1; ; attempt++ {
+		if err := h.writeBreaker.allow(time.Now()); err != nil {
+			return attempt - 1, err
+		}
+		start := time.Now()
+		ctx, cancel := h.callContext(h.writeTimeout)
+		_, err := service.Write(req).Context(ctx).Do()
+		cancel()
+		h.stats.write(len(req.Entries), time.Since(start))
+		if err == nil {
+			h.writeBreaker.done(nil, time.Now())
+			h.stats.written.Add(uint64(len(req.Entries)))
+			return attempt, nil
+		}
+		errs := entryErrors(err, len(req.Entries))
+		if errs == nil {
+			h.stats.failed.Add(uint64(len(req.Entries)))
+			h.writeBreaker.done(err, time.Now())
+			return attempt, err
+		}
+		// rejected log entries do not count as failures of the service
+		h.writeBreaker.done(nil, time.Now())
+		h.stats.failed.Add(uint64(len(errs)))
+		var entries []*logging.LogEntry
+		var retry []*record
+		for i, le := range req.Entries {
+			status, ok := errs[i]
+			switch {
+			case !ok && req.PartialSuccess:
+				// written
+				h.stats.written.Add(1)
+				continue
+			case !ok, retryable(status.code) && attempt <= partialRetries:
+				entries, retry = append(entries, le), append(retry, records[i])
+				continue
+			}
+			h.handlePartialError(&EntryError{
+				Entry:    records[i].entry,
+				LogEntry: le,
+				Code:     status.code,
+				Message:  status.message,
+				Attempts: attempt,
+			})
+		}
+		if len(entries) == 0 {
+			return attempt, nil
+		}
+		h.stats.retried.Add(uint64(len(entries)))
+		if !h.sleep(time.Duration(attempt) * 500 * time.Millisecond) {
+			return attempt, h.ctx.Err()
+		}
+		req.Entries, records = entries, retry
+	}
+}
+
+// handlePartialError passes the entry error to the partial error handler,
+// or to the error handler when no partial error handler is set.
+func (h *Hook) handlePartialError(err *EntryError) {
+	if h.partialErrorHandler != nil {
+		h.stats.drop(DropRejected)
+		h.partialErrorHandler(err)
+		return
+	}
+	h.handleError(&DeliveryError{
+		Transport: TransportAPI,
+		Entry:     err.Entry,
+		Attempt:   err.Attempts,
+		Dropped:   true,
+		Reason:    DropRejected,
+		Err:       fmt.Errorf("cannot deliver log entry: %w", err),
+	})
+}
+
+// entryStatus is the status of a log entry rejected by a write.
+type entryStatus struct {
+	code    codes.Code
+	message string
+}
+
+// entryErrors returns the per-entry errors of a failed write of n log
+// entries, keyed by the index of the log entry in the write request. Returns
+// nil if the error does not list per-entry errors.
+func entryErrors(err error, n int) map[int]entryStatus {
+	var e *googleapi.Error
+	if !errors.As(err, &e) {
+		return nil
+	}
+	var errs map[int]entryStatus
+	for _, d := range e.Details {
+		m, ok := d.(map[string]interface{})
+		if !ok || m["@type"] != partialErrorsType {
+			continue
+		}
+		entries, _ := m["logEntryErrors"].(map[string]interface{})
+		for k, v := range entries {
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 || n <= i {
+				continue
+			}
+			status, _ := v.(map[string]interface{})
+			code, _ := status["code"].(float64)
+			message, _ := status["message"].(string)
+			if errs == nil {
+				errs = make(map[int]entryStatus)
+			}
+			errs[i] = entryStatus{
+				code:    codes.Code(code),
+				message: message,
+			}
+		}
+	}
+	return errs
+}
+
+// retryable returns true if a log entry rejected with the code can be
+// written again.
+func retryable(code codes.Code) bool {
+	switch code {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted, codes.Internal:
+		return true
+	}
+	return false
+}
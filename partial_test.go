@@ -0,0 +1,173 @@
+package sdhook
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	logging "google.golang.org/api/logging/v2"
+	"google.golang.org/grpc/codes"
+)
+
+// partialError returns the details of a write error rejecting the log
+// entries with the codes, keyed by index.
+func partialError(errs map[int]codes.Code) map[string]interface{} {
+	entries := make(map[string]interface{})
+	for i, code := range errs {
+		entries[fmt.Sprint(i)] = map[string]interface{}{
+			"code":    float64(code),
+			"message": code.String(),
+		}
+	}
+	return map[string]interface{}{
+		"@type":          partialErrorsType,
+		"logEntryErrors": entries,
+	}
+}
+
+func TestEntryErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		n    int
+		exp  map[int]codes.Code
+	}{
+		{"nil", nil, 1, nil},
+		{"not api error", errors.New("failed"), 1, nil},
+		{"no details", &googleapi.Error{Code: http.StatusBadRequest}, 1, nil},
+		{
+			"other details",
+			&googleapi.Error{Details: []interface{}{map[string]interface{}{"@type": "type.googleapis.com/google.rpc.ErrorInfo"}}},
+			1, nil,
+		},
+		{
+			"entries",
+			&googleapi.Error{Details: []interface{}{partialError(map[int]codes.Code{0: codes.InvalidArgument, 2: codes.Unavailable})}},
+			3, map[int]codes.Code{0: codes.InvalidArgument, 2: codes.Unavailable},
+		},
+		{
+			"out of range",
+			&googleapi.Error{Details: []interface{}{partialError(map[int]codes.Code{1: codes.InvalidArgument, 5: codes.Internal})}},
+			2, map[int]codes.Code{1: codes.InvalidArgument},
+		},
+		{
+			"wrapped",
+			fmt.Errorf("write: %w", &googleapi.Error{Details: []interface{}{partialError(map[int]codes.Code{0: codes.PermissionDenied})}}),
+			1, map[int]codes.Code{0: codes.PermissionDenied},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs := entryErrors(test.err, test.n)
+			if len(errs) != len(test.exp) {
+				t.Fatalf("expected %d entry errors, got: %d", len(test.exp), len(errs))
+			}
+			if test.exp == nil && errs != nil {
+				t.Errorf("expected nil entry errors")
+			}
+			for i, code := range test.exp {
+				status, ok := errs[i]
+				if !ok {
+					t.Fatalf("expected entry error %d", i)
+				}
+				if status.code != code {
+					t.Errorf("expected entry %d code %s, got: %s", i, code, status.code)
+				}
+				if status.message != code.String() {
+					t.Errorf("expected entry %d message %q, got: %q", i, code.String(), status.message)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		code codes.Code
+		exp  bool
+	}{
+		{codes.OK, false},
+		{codes.InvalidArgument, false},
+		{codes.PermissionDenied, false},
+		{codes.NotFound, false},
+		{codes.Unavailable, true},
+		{codes.DeadlineExceeded, true},
+		{codes.ResourceExhausted, true},
+		{codes.Aborted, true},
+		{codes.Internal, true},
+	}
+	for _, test := range tests {
+		t.Run(test.code.String(), func(t *testing.T) {
+			if v := retryable(test.code); v != test.exp {
+				t.Errorf("expected %t, got: %t", test.exp, v)
+			}
+		})
+	}
+}
+
+func TestPartialSuccess(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     codes.Code
+		writes   int
+		rejected int
+	}{
+		{"retried", codes.Unavailable, 2, 0},
+		{"rejected", codes.InvalidArgument, 1, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := &fakeLogging{
+				respond: func(n int, req *logging.WriteLogEntriesRequest) (int, string) {
+					if n != 0 {
+						return http.StatusOK, "{}"
+					}
+					return http.StatusBadRequest, fmt.Sprintf(`{"error":{"code":400,"message":"partial","details":[{"@type":%q,"logEntryErrors":{"1":{"code":%d,"message":"bad"}}}]}}`, partialErrorsType, test.code)
+				},
+			}
+			var mu sync.Mutex
+			var rejected []*EntryError
+			h := newTestHook(t, f,
+				PartialSuccess(true),
+				PartialRetries(1),
+				Batching(2, time.Second),
+				PartialErrorHandler(func(err *EntryError) {
+					mu.Lock()
+					defer mu.Unlock()
+					rejected = append(rejected, err)
+				}),
+			)
+			l := newTestLogger(h)
+			l.Info("first")
+			l.Info("second")
+			h.Wait()
+			reqs := f.requests()
+			if len(reqs) != test.writes {
+				t.Fatalf("expected %d writes, got: %d", test.writes, len(reqs))
+			}
+			if len(reqs[0].Entries) != 2 || !reqs[0].PartialSuccess {
+				t.Errorf("expected first write of 2 entries with partial success")
+			}
+			// log entries are dispatched concurrently, so their order in the
+			// batch varies
+			if test.writes > 1 && (len(reqs[1].Entries) != 1 || reqs[1].Entries[0].InsertId != reqs[0].Entries[1].InsertId) {
+				t.Errorf("expected only the rejected entry to be written again")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(rejected) != test.rejected {
+				t.Fatalf("expected %d rejected entries, got: %d", test.rejected, len(rejected))
+			}
+			if test.rejected != 0 && rejected[0].Code != test.code {
+				t.Errorf("expected code %s, got: %s", test.code, rejected[0].Code)
+			}
+			if s := h.Stats(); s.Written != 2-uint64(test.rejected) || s.Failed != 1 {
+				t.Errorf("expected %d written and 1 failed, got: %d and %d", 2-test.rejected, s.Written, s.Failed)
+			}
+		})
+	}
+}
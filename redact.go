@@ -0,0 +1,183 @@
+package sdhook
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// RedactedValue is the replacement for values masked by redaction.
+const RedactedValue = "[REDACTED]"
+
+// RedactAction is the action taken on values matched by a redaction rule.
+type RedactAction int
+
+// RedactAction values.
+const (
+	// RedactMask replaces matched values with RedactedValue.
+	RedactMask RedactAction = iota
+	// RedactHash replaces matched values with a truncated SHA-256 hash of
+	// the value, prefixed with "sha256:". Hashed values can be correlated
+	// with each other, but as the hash is unsalted, low entropy values can
+	// be recovered by brute force.
+	RedactHash
+	// RedactDrop removes matched fields or query parameters.
+	RedactDrop
+)
+
+// RedactRule is a rule for redacting log entry fields and HTTP request URLs
+// before they are sent. Exactly one of Field, Glob, Value or QueryParam must
+// be set.
+type RedactRule struct {
+	// Field is the name of the fields to redact, compared case
+	// insensitively.
+	Field string
+	// Glob is a path.Match pattern matching the names of the fields to
+	// redact, compared case insensitively.
+	Glob string
+	// Value is a regular expression matching the parts of field values and
+	// log messages to redact. With RedactDrop, fields with a matching value
+	// are removed, and the matching parts of log messages are masked.
+	Value *regexp.Regexp
+	// QueryParam is the name of the query parameters to redact in HTTP
+	// request URLs and referers.
+	QueryParam string
+	// Action is the action taken on matched values.
+	Action RedactAction
+}
+
+// validate validates the redaction rule.
+func (rule RedactRule) validate() error {
+	var n int
+	for _, ok := range []bool{rule.Field != "", rule.Glob != "", rule.Value != nil, rule.QueryParam != ""} {
+		if ok {
+			n++
+		}
+	}
+	if n != 1 {
+		return errors.New("redact rule must set exactly one of Field, Glob, Value or QueryParam")
+	}
+	if rule.Glob != "" {
+		if _, err := path.Match(rule.Glob, ""); err != nil {
+			return errors.New("invalid redact rule glob " + rule.Glob)
+		}
+	}
+	if rule.Action < RedactMask || rule.Action > RedactDrop {
+		return errors.New("invalid redact rule action")
+	}
+	return nil
+}
+
+// matchField returns true if the rule matches the field name.
+func (rule RedactRule) matchField(name string) bool {
+	switch {
+	case rule.Field != "":
+		return strings.EqualFold(rule.Field, name)
+	case rule.Glob != "":
+		ok, _ := path.Match(strings.ToLower(rule.Glob), strings.ToLower(name))
+		return ok
+	}
+	return false
+}
+
+// redactValue applies the action to the value.
+func redactValue(action RedactAction, v string) string {
+	if action == RedactHash {
+		sum := sha256.Sum256([]byte(v))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	}
+	return RedactedValue
+}
+
+// redact applies the redaction rules to the record's message, labels and
+// HTTP request.
+func (h *Hook) redact(r *record) {
+	if len(h.redactRules) == 0 {
+		return
+	}
+	if msg := h.redactMessage(r.entry.Message); msg != r.entry.Message {
+		// the logrus entry is shared with the other hooks, so it is copied
+		entry := *r.entry
+		entry.Message = msg
+		r.entry = &entry
+	}
+	for k, v := range r.labels {
+		var drop bool
+		for _, rule := range h.redactRules {
+			switch {
+			case rule.matchField(k):
+				drop = rule.Action == RedactDrop
+				v = redactValue(rule.Action, v)
+			case rule.Value != nil && rule.Value.MatchString(v):
+				drop = rule.Action == RedactDrop
+				v = rule.Value.ReplaceAllStringFunc(v, func(s string) string {
+					return redactValue(rule.Action, s)
+				})
+			}
+			if drop {
+				break
+			}
+		}
+		if drop {
+			delete(r.labels, k)
+		} else {
+			r.labels[k] = v
+		}
+	}
+	if r.httpReq != nil {
+		req := *r.httpReq
+		req.RequestUrl = h.redactURL(req.RequestUrl)
+		req.Referer = h.redactURL(req.Referer)
+		r.httpReq = &req
+	}
+}
+
+// redactMessage applies the value redaction rules to the message.
+func (h *Hook) redactMessage(msg string) string {
+	for _, rule := range h.redactRules {
+		if rule.Value == nil {
+			continue
+		}
+		msg = rule.Value.ReplaceAllStringFunc(msg, func(s string) string {
+			return redactValue(rule.Action, s)
+		})
+	}
+	return msg
+}
+
+// redactURL applies the query parameter redaction rules to the URL.
+func (h *Hook) redactURL(s string) string {
+	if s == "" || !strings.Contains(s, "?") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	q := u.Query()
+	var changed bool
+	for k, v := range q {
+		for _, rule := range h.redactRules {
+			if rule.QueryParam == "" || !strings.EqualFold(rule.QueryParam, k) {
+				continue
+			}
+			changed = true
+			if rule.Action == RedactDrop {
+				q.Del(k)
+				break
+			}
+			for i := range v {
+				v[i] = redactValue(rule.Action, v[i])
+			}
+		}
+	}
+	if !changed {
+		return s
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,62 @@
+package sdhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRedactMiddleware(t *testing.T) {
+	f := new(fakeLogging)
+	h := newTestHook(t, f, Redact(RedactRule{QueryParam: "token"}))
+	handler := Middleware(newTestLogger(h))(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/x?token=secret&a=b", nil))
+	h.Wait()
+	reqs := f.requests()
+	if len(reqs) != 1 || len(reqs[0].Entries) != 1 {
+		t.Fatalf("expected 1 write of 1 entry, got: %d", len(reqs))
+	}
+	le := reqs[0].Entries[0]
+	if le.TextPayload != "GET /x" {
+		t.Errorf("expected text payload %q, got: %q", "GET /x", le.TextPayload)
+	}
+	if le.HttpRequest == nil {
+		t.Fatalf("expected http request")
+	}
+	if u := le.HttpRequest.RequestUrl; strings.Contains(u, "secret") || !strings.Contains(u, "a=b") {
+		t.Errorf("expected token to be redacted from request url, got: %q", u)
+	}
+}
+
+func TestRedactMessage(t *testing.T) {
+	card := regexp.MustCompile(`\d{16}`)
+	tests := []struct {
+		name string
+		rule RedactRule
+		msg  string
+		exp  string
+	}{
+		{"mask", RedactRule{Value: card}, "card 1234567812345678 declined", "card " + RedactedValue + " declined"},
+		{"hash", RedactRule{Value: card, Action: RedactHash}, "card 1234567812345678", "card " + redactValue(RedactHash, "1234567812345678")},
+		{"drop", RedactRule{Value: card, Action: RedactDrop}, "card 1234567812345678", "card " + RedactedValue},
+		{"no match", RedactRule{Value: card}, "card declined", "card declined"},
+		{"field", RedactRule{Field: "card"}, "card 1234567812345678", "card 1234567812345678"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := new(fakeLogging)
+			h := newTestHook(t, f, Redact(test.rule))
+			newTestLogger(h).Info(test.msg)
+			h.Wait()
+			reqs := f.requests()
+			if len(reqs) != 1 || len(reqs[0].Entries) != 1 {
+				t.Fatalf("expected 1 write of 1 entry, got: %d", len(reqs))
+			}
+			if s := reqs[0].Entries[0].TextPayload; s != test.exp {
+				t.Errorf("expected text payload %q, got: %q", test.exp, s)
+			}
+		})
+	}
+}
@@ -63,3 +63,64 @@ const (
 	ResTypeTestserviceMatrix       ResType = "testservice_matrix"
 	ResTypeVpnGateway              ResType = "vpn_gateway"
 )
+
+// ResTypes returns the ResType values.
+func ResTypes() []ResType {
+	return []ResType{
+		ResTypeAPI,
+		ResTypeAppScriptFunction,
+		ResTypeAwsEc2Instance,
+		ResTypeBigqueryResource,
+		ResTypeBuild,
+		ResTypeClientAuthConfigBrand,
+		ResTypeClientAuthConfigClient,
+		ResTypeCloudDebuggerResource,
+		ResTypeCloudFunction,
+		ResTypeCloudRunRevision,
+		ResTypeCloudsqlDatabase,
+		ResTypeContainer,
+		ResTypeDataflowStep,
+		ResTypeDataprocCluster,
+		ResTypeDeployment,
+		ResTypeDeploymentManagerType,
+		ResTypeDNSManagedZone,
+		ResTypeGaeApp,
+		ResTypeGceAutoscaler,
+		ResTypeGceBackendService,
+		ResTypeGceDisk,
+		ResTypeGceFirewallRule,
+		ResTypeGceForwardingRule,
+		ResTypeGceHealthCheck,
+		ResTypeGceImage,
+		ResTypeGceInstance,
+		ResTypeGceInstanceGroup,
+		ResTypeGceInstanceGroupManager,
+		ResTypeGceInstanceTemplate,
+		ResTypeGceNetwork,
+		ResTypeGceOperation,
+		ResTypeGceProject,
+		ResTypeGceReservedAddress,
+		ResTypeGceRoute,
+		ResTypeGceRouter,
+		ResTypeGceSnapshot,
+		ResTypeGceSslCertificate,
+		ResTypeGceSubnetwork,
+		ResTypeGceTargetHTTPProxy,
+		ResTypeGceTargetHTTPSProxy,
+		ResTypeGceTargetPool,
+		ResTypeGceURLMap,
+		ResTypeGcsBucket,
+		ResTypeGkeCluster,
+		ResTypeGlobal,
+		ResTypeHTTPLoadBalancer,
+		ResTypeLoggingLog,
+		ResTypeLoggingSink,
+		ResTypeMetric,
+		ResTypeMlJob,
+		ResTypeOrganization,
+		ResTypeProject,
+		ResTypeServiceAccount,
+		ResTypeTestserviceMatrix,
+		ResTypeVpnGateway,
+	}
+}
@@ -0,0 +1,205 @@
+package sdhook
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	errorReporting "google.golang.org/api/clouderrorreporting/v1beta1"
+	logging "google.golang.org/api/logging/v2"
+)
+
+// LogNameRule is a log name routing rule, returning the name of the log for
+// a logrus entry, or an empty string when the rule does not apply.
+type LogNameRule func(*logrus.Entry) string
+
+// LevelLogNameRule returns a log name routing rule that routes log entries
+// at the levels to the named log.
+func LevelLogNameRule(name string, levels ...logrus.Level) LogNameRule {
+	return func(entry *logrus.Entry) string {
+		for _, l := range levels {
+			if entry.Level == l {
+				return name
+			}
+		}
+		return ""
+	}
+}
+
+// FieldLogNameRule returns a log name routing rule that routes log entries
+// whose field has the value to the named log.
+func FieldLogNameRule(name, field string, value interface{}) LogNameRule {
+	return func(entry *logrus.Entry) string {
+		if v, ok := entry.Data[field]; ok && v == value {
+			return name
+		}
+		return ""
+	}
+}
+
+// routeLogName returns the name of the log for the entry from the hook's
+// log name rules, or an empty string if no rule applies.
+func (h *Hook) routeLogName(entry *logrus.Entry) string {
+	for _, rule := range h.logNameRules {
+		if name := rule(entry); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// logPath returns the full resource name of the named log in the project.
+// If the project is not set, the name is returned as-is.
+func logPath(projectID, name string) string {
+	if projectID == "" || strings.HasPrefix(name, "projects/") {
+		return name
+	}
+	return "projects/" + projectID + "/logs/" + url.PathEscape(name)
+}
+
+// Route is a destination for log entries, chosen per log entry by a route
+// rule. Routes only apply to log entries written to the logging API and
+// errors reported to the error reporting API.
+type Route struct {
+	// ProjectID is the project the log entries are sent to. When empty, the
+	// hook's project is used.
+	ProjectID string
+	// Resource is the monitored resource sent with the log entries. When
+	// nil, the hook's monitored resource is used.
+	Resource *logging.MonitoredResource
+	// Credentials is the key of the credentials used to send the log
+	// entries, resolved with the credentials function set with the
+	// RouteCredentials option. When empty, the hook's services are used.
+	Credentials string
+}
+
+// RouteRule is a routing rule, returning the route for a logrus entry, or
+// nil when the rule does not apply.
+type RouteRule func(*logrus.Entry) *Route
+
+// FieldRouteRule returns a routing rule that routes log entries by the
+// value of the field, such as a "tenant" field.
+func FieldRouteRule(field string, routes map[string]Route) RouteRule {
+	return func(entry *logrus.Entry) *Route {
+		v, ok := entry.Data[field]
+		if !ok {
+			return nil
+		}
+		if rt, ok := routes[fmt.Sprintf("%v", v)]; ok {
+			return &rt
+		}
+		return nil
+	}
+}
+
+// CredentialsFunc returns the HTTP client for the credentials key of a
+// route.
+type CredentialsFunc func(key string) (*http.Client, error)
+
+// CredentialsFiles returns a credentials function loading Google Service
+// Account credentials from the file for each credentials key.
+func CredentialsFiles(files map[string]string) CredentialsFunc {
+	return func(key string) (*http.Client, error) {
+		path, ok := files[key]
+		if !ok {
+			return nil, fmt.Errorf("no credentials file for %q", key)
+		}
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		_, client, err := serviceAccountClient(buf)
+		return client, err
+	}
+}
+
+// route returns the route for the entry from the hook's routing rules, with
+// the hook's project and monitored resource filled in.
+func (h *Hook) route(entry *logrus.Entry) Route {
+	var rt Route
+	for _, rule := range h.routeRules {
+		if r := rule(entry); r != nil {
+			rt = *r
+			break
+		}
+	}
+	if rt.ProjectID == "" {
+		rt.ProjectID = h.projectID
+	}
+	if rt.Resource == nil {
+		rt.Resource = h.resource
+	}
+	return rt
+}
+
+// routeClient is a cached client for a route's credentials.
+type routeClient struct {
+	service      *logging.EntriesService
+	errorService *errorReporting.Service
+}
+
+// services returns the logging and error reporting services for the
+// credentials key, creating and caching them on first use.
+func (h *Hook) services(credentials string) (*logging.EntriesService, *errorReporting.Service, error) {
+	if credentials == "" {
+		return h.service, h.errorService, nil
+	}
+	h.clientsMu.Lock()
+	defer h.clientsMu.Unlock()
+	if c, ok := h.clients[credentials]; ok {
+		return c.service, c.errorService, nil
+	}
+	if h.credentials == nil {
+		return nil, nil, fmt.Errorf("no credentials function for %q", credentials)
+	}
+	client, err := h.credentials(credentials)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot load credentials %q: %w", credentials, err)
+	}
+	l, err := logging.New(client)
+	if err != nil {
+		return nil, nil, err
+	}
+	e, err := errorReporting.New(client)
+	if err != nil {
+		return nil, nil, err
+	}
+	if h.clients == nil {
+		h.clients = make(map[string]*routeClient)
+	}
+	h.clients[credentials] = &routeClient{
+		service:      l.Entries,
+		errorService: e,
+	}
+	return l.Entries, e, nil
+}
+
+// retarget returns the full resource name of the log in the project.
+func retarget(logName, projectID string) string {
+	if i := strings.Index(logName, "/logs/"); i != -1 && strings.HasPrefix(logName, "projects/") {
+		return "projects/" + projectID + logName[i:]
+	}
+	return logPath(projectID, logName)
+}
+
+// resourceKey returns a key identifying the monitored resource.
+func resourceKey(res *logging.MonitoredResource) string {
+	if res == nil {
+		return ""
+	}
+	keys := make([]string, 0, len(res.Labels))
+	for k := range res.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString(res.Type)
+	for _, k := range keys {
+		sb.WriteString("\x00" + k + "=" + res.Labels[k])
+	}
+	return sb.String()
+}
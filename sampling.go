@@ -0,0 +1,191 @@
+package sdhook
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultSummaryInterval is the default interval between suppression
+// summary log entries when only rate limits are set.
+const DefaultSummaryInterval = time.Minute
+
+// SuppressedField is the field name of the suppression summary log entry
+// counts.
+const SuppressedField = "suppressed"
+
+// samplerCounters is the number of sampler counters. Log entries are
+// counted by the hash of their level and message, so that the sampler's
+// memory is bounded, at the cost of log entries with colliding hashes
+// sharing a counter.
+const samplerCounters = 4096
+
+// sampler samples log entries per level and message.
+type sampler struct {
+	interval   time.Duration
+	first      uint64
+	thereafter uint64
+
+	mu       sync.Mutex
+	counters [samplerCounters]samplerCounter
+}
+
+// samplerCounter counts the log entries with the same level and message
+// hash in an interval.
+type samplerCounter struct {
+	start time.Time
+	n     uint64
+}
+
+// sample returns true if the log entry should be sent. In each interval,
+// the first log entries with the same level and message are sent, and
+// thereafter only every nth entry.
+func (s *sampler) sample(entry *logrus.Entry, now time.Time) bool {
+	hash := fnv.New32a()
+	hash.Write([]byte{byte(entry.Level)})
+	hash.Write([]byte(entry.Message))
+	s.mu.Lock()
+	c := &s.counters[hash.Sum32()%samplerCounters]
+	if now.Sub(c.start) >= s.interval {
+		c.start, c.n = now, 0
+	}
+	c.n++
+	n := c.n
+	s.mu.Unlock()
+	switch {
+	case n <= s.first:
+		return true
+	case s.thereafter == 0:
+		return false
+	}
+	return (n-s.first)%s.thereafter == 0
+}
+
+// tokenBucket is a token bucket rate limiter.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full token bucket.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// allow returns true if a token was available and taken.
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// suppression holds the counts of suppressed log entries since the last
+// suppression summary.
+type suppression struct {
+	// once starts the summaries on the first suppressed log entry.
+	once   sync.Once
+	mu     sync.Mutex
+	last   time.Time
+	logger *logrus.Logger
+	counts map[string]uint64
+}
+
+// allow returns true if the log entry passes the hook's sampling and rate
+// limits. Suppressed log entries are counted for the suppression summary.
+func (h *Hook) allow(entry *logrus.Entry, now time.Time) bool {
+	var reason string
+	switch {
+	case h.sampler != nil && !h.samplingDisabled && !h.sampler.sample(entry, now):
+		reason = DropSampled
+	case h.rateLimits[entry.Level] != nil && !h.rateLimits[entry.Level].allow(now):
+		reason = DropRateLimited
+	default:
+		return true
+	}
+	h.stats.drop(reason)
+	h.suppression.once.Do(func() {
+		go h.runSummaries()
+	})
+	h.suppression.mu.Lock()
+	defer h.suppression.mu.Unlock()
+	if h.suppression.counts == nil {
+		h.suppression.counts = make(map[string]uint64)
+		h.suppression.last = now
+	}
+	h.suppression.counts[reason]++
+	h.suppression.logger = entry.Logger
+	return false
+}
+
+// runSummaries sends the suppression summaries every summary interval,
+// until the hook is closed.
+func (h *Hook) runSummaries() {
+	for {
+		h.mu.RLock()
+		interval := h.summaryInterval
+		h.mu.RUnlock()
+		t := time.NewTimer(interval)
+		select {
+		case <-h.ctx.Done():
+			t.Stop()
+			return
+		case now := <-t.C:
+			h.summarize(now)
+		}
+	}
+}
+
+// summarize sends a suppression summary log entry at the warning level when
+// log entries have been suppressed since the last summary. The summary is
+// not sent when the warning level is not enabled.
+func (h *Hook) summarize(now time.Time) {
+	h.suppression.mu.Lock()
+	counts, last, logger := h.suppression.counts, h.suppression.last, h.suppression.logger
+	h.suppression.counts = nil
+	h.suppression.mu.Unlock()
+	h.mu.RLock()
+	enabled := h.levelEnabled(logrus.WarnLevel)
+	h.mu.RUnlock()
+	if len(counts) == 0 || !enabled {
+		return
+	}
+	var total uint64
+	fields := make(logrus.Fields, len(counts)+1)
+	for reason, n := range counts {
+		fields[SuppressedField+"."+reason] = n
+		total += n
+	}
+	fields[SuppressedField] = total
+	if logger == nil {
+		logger = logrus.StandardLogger()
+	}
+	h.dispatch(&logrus.Entry{
+		Logger:  logger,
+		Data:    fields,
+		Time:    now,
+		Level:   logrus.WarnLevel,
+		Message: fmt.Sprintf("suppressed %d log entries since %s", total, last.Format(time.RFC3339)),
+	})
+}
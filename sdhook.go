@@ -3,14 +3,18 @@
 package sdhook
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"net/netip"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/facebookgo/stack"
@@ -25,6 +29,34 @@ import (
 // account credentials.
 const defaultName = "default"
 
+// Special logrus field names that are sent as the corresponding log entry
+// fields instead of as labels. The names match the special fields recognized
+// by the Google logging agent in structured payloads.
+const (
+	// TraceField is the field name for the trace associated with a log
+	// entry. If the trace is not a full resource name, it will be qualified
+	// as "projects/{projectID}/traces/{trace}".
+	TraceField = "logging.googleapis.com/trace"
+	// SpanIDField is the field name for the span associated with a log entry.
+	SpanIDField = "logging.googleapis.com/spanId"
+	// TraceSampledField is the field name for the sampling decision of the
+	// trace associated with a log entry.
+	TraceSampledField = "logging.googleapis.com/trace_sampled"
+	// InsertIDField is the field name for the unique identifier of a log
+	// entry, used by Stackdriver to deduplicate entries. When not set, an
+	// id is generated when the entry is fired.
+	InsertIDField = "logging.googleapis.com/insertId"
+	// OperationField is the field name for the operation associated with a
+	// log entry. The field's value must be a *Operation or a
+	// *logging.LogEntryOperation.
+	OperationField = "logging.googleapis.com/operation"
+	// SourceLocationField is the field name for the source code location
+	// associated with a log entry, also used as the report location of
+	// errors sent to error reporting. The field's value must be a
+	// *logging.LogEntrySourceLocation.
+	SourceLocationField = "logging.googleapis.com/sourceLocation"
+)
+
 // Hook provides a Google Stackdriver logging hook for use with logrus.
 type Hook struct {
 	// levels are the levels that logrus will hook to.
@@ -44,9 +76,28 @@ type Hook struct {
 	// partialSuccess allows partial writes of log entries if there is a badly
 	// formatted log.
 	partialSuccess bool
+	// partialRetries is the number of times log entries rejected with a
+	// retryable error are written again.
+	partialRetries int
+	// partialErrorHandler is called for each log entry rejected by a write.
+	partialErrorHandler func(*EntryError)
+	// errorHandler is called for errors encountered while delivering log
+	// entries.
+	errorHandler func(*DeliveryError)
+	// sinks are the sinks log entries are delivered to. When not set, log
+	// entries are delivered to the logging agent or API.
+	sinks []sinkFilter
 	// agentClient defines the fluentd logger object that can send data to
 	// to the Google logging agent.
 	agentClient *fluent.Fluent
+	// agentAddr is the address of the logging agent.
+	agentAddr string
+	// agentQueue are the records posted to the logging agent.
+	agentQueue agentQueue
+	// computeCredentials indicates the GCE compute credentials of
+	// computeServiceAccount are used, for checking scopes with Verify.
+	computeCredentials    bool
+	computeServiceAccount string
 	// errorReportingServiceName defines the value of the field <service>,
 	// required for a valid error reporting payload. If this value is set,
 	// messages where level/severity is higher than or equal to "error" will
@@ -67,31 +118,130 @@ type Hook struct {
 	// See more at:
 	// https://cloud.google.com/error-reporting/docs/formatting-error-messages
 	useLoggingServiceForErrors bool
+	// maxLabels is the maximum number of labels per log entry.
+	maxLabels int
+	// maxLabelKeySize is the maximum size of a label key.
+	maxLabelKeySize int
+	// maxLabelValueSize is the maximum size of a label value.
+	maxLabelValueSize int
+	// labelStats are the counts of labels modified to satisfy the label
+	// limits.
+	labelStats labelStats
+	// maxEntrySize is the maximum size of a log entry.
+	maxEntrySize int
+	// oversizeMode is the handling of log entries exceeding the maximum
+	// entry size.
+	oversizeMode OversizeMode
+	// trustedProxies are the proxies whose X-Forwarded-For headers are used
+	// to determine the client IP of a request.
+	trustedProxies []netip.Prefix
+	// sampler samples log entries per level and message.
+	sampler *sampler
+	// samplingDisabled disables the sampler.
+	samplingDisabled bool
+	// rateLimits are the rate limiters per level.
+	rateLimits map[logrus.Level]*tokenBucket
+	// summaryInterval is the interval between suppression summaries.
+	summaryInterval time.Duration
+	// suppression are the suppressed log entry counts.
+	suppression suppression
+	// logNameField is the field containing the name of the log to route
+	// entries to.
+	logNameField string
+	// logNameRules are the log name routing rules.
+	logNameRules []LogNameRule
+	// routeRules are the routing rules.
+	routeRules []RouteRule
+	// credentials resolves the credentials keys of routes.
+	credentials CredentialsFunc
+	// clients are the cached clients per credentials key.
+	clients map[string]*routeClient
+	// clientsMu protects clients.
+	clientsMu sync.Mutex
+	// batcher batches log entries per destination.
+	batcher *batcher
+	// writeBreaker is the circuit breaker for writes to the logging API.
+	writeBreaker *breaker
+	// reportBreaker is the circuit breaker for reports to the error
+	// reporting API.
+	reportBreaker *breaker
+	// redactRules are the redaction rules applied to log entries.
+	redactRules []RedactRule
+	// errorDedup collapses repeated error reports.
+	errorDedup *errorDedup
+	// stats are the delivery statistics.
+	stats *stats
+	// expvarName is the name of the expvar variable the statistics are
+	// published as.
+	expvarName string
+	// lastError is the last delivery error.
+	lastError lastError
+	// insertIDPrefix is the random prefix of generated insert ids.
+	insertIDPrefix string
+	// insertIDCounter is the sequence number of the last generated insert
+	// id.
+	insertIDCounter atomic.Uint64
+	// writeTimeout is the timeout of writes to the logging API.
+	writeTimeout time.Duration
+	// reportTimeout is the timeout of reports to the error reporting API.
+	reportTimeout time.Duration
+	// ctx is the hook's context, cancelled when the hook is closed.
+	ctx context.Context
+	// cancel cancels the hook's context.
+	cancel context.CancelFunc
+	// closed indicates whether the hook was closed.
+	closed atomic.Bool
+	// mu protects the settings changed by Update.
+	mu sync.RWMutex
 	// waitGroup holds counters for each subroutine fired
 	waitGroup sync.WaitGroup
+	// pending tracks the subroutines fired, for Flush.
+	pending tracker
 }
 
 // New creates a StackdriverHook using the provided options that is suitible
 // for using with logrus for logging to Google Stackdriver.
 func New(opts ...Option) (*Hook, error) {
 	h := &Hook{
-		levels: logrus.AllLevels,
+		levels:            logrus.AllLevels,
+		maxLabels:         DefaultMaxLabels,
+		maxLabelKeySize:   DefaultMaxLabelKeySize,
+		maxLabelValueSize: DefaultMaxLabelValueSize,
+		maxEntrySize:      DefaultMaxEntrySize,
+		partialRetries:    DefaultPartialRetries,
+		insertIDPrefix:    randomID(8),
+		summaryInterval:   DefaultSummaryInterval,
+		writeTimeout:      DefaultWriteTimeout,
+		reportTimeout:     DefaultReportTimeout,
+		stats:             newStats(),
 	}
+	h.ctx, h.cancel = context.WithCancel(context.Background())
 	// apply opts
 	for _, o := range opts {
 		if err := o(h); err != nil {
 			return nil, err
 		}
 	}
-	// check service, resource, logName set
-	if h.service == nil && h.agentClient == nil {
-		return nil, errors.New("no stackdriver service was provided")
-	}
-	if h.resource == nil && h.agentClient == nil {
-		return nil, errors.New("the monitored resource was not provided")
+	// check sinks
+	useAPI := len(h.sinks) == 0 && h.agentClient == nil
+	for _, s := range h.sinks {
+		api, agent := usesSink(s.sink)
+		if agent && h.agentClient == nil {
+			return nil, errors.New("no logging agent was provided")
+		}
+		useAPI = useAPI || api
 	}
-	if h.projectID == "" && h.agentClient == nil {
-		return nil, errors.New("the project id was not provided")
+	// check service, resource, logName set
+	if useAPI {
+		if h.service == nil {
+			return nil, errors.New("no stackdriver service was provided")
+		}
+		if h.resource == nil {
+			return nil, errors.New("the monitored resource was not provided")
+		}
+		if h.projectID == "" {
+			return nil, errors.New("the project id was not provided")
+		}
 	}
 	// set default project name
 	if h.logName == "" {
@@ -104,49 +254,64 @@ func New(opts ...Option) (*Hook, error) {
 	if h.errorReportingLogName == "" {
 		h.errorReportingLogName = h.logName + "_errors"
 	}
+	// publish stats last, as published expvar variables cannot be removed
+	if h.expvarName != "" {
+		if err := h.publishExpvar(); err != nil {
+			return nil, err
+		}
+	}
 	return h, nil
 }
 
-// Levels returns the logrus levels that this hook is applied to. This can be
-// set using the Levels Option.
+// Levels returns the logrus levels that this hook is applied to. As the
+// levels set using the Levels Option can be changed with Update, all levels
+// are returned, and log entries at other levels are ignored by Fire.
 func (h *Hook) Levels() []logrus.Level {
-	return h.levels
+	return logrus.AllLevels
 }
 
 // Fire writes the message to the Stackdriver entry service.
 func (h *Hook) Fire(entry *logrus.Entry) error {
+	now := time.Now()
+	h.mu.RLock()
+	if !h.levelEnabled(entry.Level) {
+		h.mu.RUnlock()
+		return nil
+	}
+	h.stats.fired.Add(1)
+	if h.closed.Load() {
+		h.mu.RUnlock()
+		h.stats.drop(DropClosed)
+		return nil
+	}
+	ok := h.allow(entry, now)
+	h.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	h.dispatch(copyEntry(entry))
+	return nil
+}
+
+// dispatch asynchronously delivers the log entry.
+func (h *Hook) dispatch(entry *logrus.Entry) {
+	// generate the insert id when the entry is fired, so that it remains
+	// stable across retries
+	if _, ok := entry.Data[InsertIDField]; !ok {
+		entry.Data[InsertIDField] = h.nextInsertID()
+	}
+	h.stats.queueDepth.Add(1)
 	h.waitGroup.Add(1)
+	e := h.pending.add()
 	go func(entry *logrus.Entry) {
 		defer h.waitGroup.Done()
-		var httpReq *logging.HttpRequest
-		// convert entry data to labels
-		labels := make(map[string]string, len(entry.Data))
-		for k, v := range entry.Data {
-			switch x := v.(type) {
-			case string:
-				labels[k] = x
-			case *http.Request:
-				httpReq = &logging.HttpRequest{
-					Referer:       x.Referer(),
-					RemoteIp:      x.RemoteAddr,
-					RequestMethod: x.Method,
-					RequestUrl:    x.URL.String(),
-					UserAgent:     x.UserAgent(),
-				}
-			case *logging.HttpRequest:
-				httpReq = x
-			default:
-				labels[k] = fmt.Sprintf("%v", v)
-			}
-		}
-		// write log entry
-		if h.agentClient != nil {
-			h.sendLogMessageViaAgent(entry, labels, httpReq)
-		} else {
-			h.sendLogMessageViaAPI(entry, labels, httpReq)
-		}
-	}(copyEntry(entry))
-	return nil
+		defer h.pending.finish(e)
+		defer h.stats.queueDepth.Add(-1)
+		h.mu.RLock()
+		r := h.newRecord(entry)
+		h.mu.RUnlock()
+		h.deliver(r)
+	}(entry)
 }
 
 // Wait will return after all subroutines have returned.
@@ -154,10 +319,116 @@ func (h *Hook) Fire(entry *logrus.Entry) error {
 // your logs are delivered before your program exits.
 // `logrus.RegisterExitHandler(h.Wait)`
 func (h *Hook) Wait() {
+	h.summarize(time.Now())
+	h.flushErrorReports()
 	h.waitGroup.Wait()
+	if h.batcher != nil {
+		h.batcher.flush()
+	}
+}
+
+// nextInsertID returns a new insert id. Generated insert ids are unique to
+// the hook, and are monotonically increasing.
+func (h *Hook) nextInsertID() string {
+	return fmt.Sprintf("%s-%016x", h.insertIDPrefix, h.insertIDCounter.Add(1))
+}
+
+// record holds the data extracted from a logrus entry.
+type record struct {
+	// entry is the logrus entry.
+	entry *logrus.Entry
+	// labels are the entry's fields converted to labels.
+	labels map[string]string
+	// httpReq is the http request associated with the entry.
+	httpReq *logging.HttpRequest
+	// trace is the trace associated with the entry.
+	trace string
+	// spanID is the span associated with the entry.
+	spanID string
+	// traceSampled indicates whether the trace was sampled.
+	traceSampled bool
+	// operation is the operation associated with the entry.
+	operation *logging.LogEntryOperation
+	// sourceLocation is the source code location associated with the entry.
+	sourceLocation *logging.LogEntrySourceLocation
+	// insertID is the unique identifier of the entry.
+	insertID string
+	// logName is the name of the log the entry is routed to, if any.
+	logName string
+	// route is the route of the entry.
+	route Route
+	// failover is the delivery by a sink of a failover chain, if any.
+	failover *failoverAttempt
+	// overflow are the labels exceeding the maximum number of labels, which
+	// are sent in the entry's payload by the logging service.
+	overflow map[string]string
+}
+
+// newRecord converts the logrus entry's data to a record.
+func (h *Hook) newRecord(entry *logrus.Entry) *record {
+	r := &record{
+		entry:  entry,
+		labels: make(map[string]string, len(entry.Data)),
+	}
+	// convert entry data to labels
+	for k, v := range entry.Data {
+		switch k {
+		case h.logNameField:
+			if k != "" {
+				r.logName = fmt.Sprintf("%v", v)
+				continue
+			}
+		case TraceField:
+			r.trace = fmt.Sprintf("%v", v)
+			continue
+		case SpanIDField:
+			r.spanID = fmt.Sprintf("%v", v)
+			continue
+		case TraceSampledField:
+			r.traceSampled, _ = strconv.ParseBool(fmt.Sprintf("%v", v))
+			continue
+		case InsertIDField:
+			r.insertID = fmt.Sprintf("%v", v)
+			continue
+		case OperationField:
+			if op, ok := v.(*logging.LogEntryOperation); ok {
+				r.operation = op
+				continue
+			}
+		case SourceLocationField:
+			if loc, ok := v.(*logging.LogEntrySourceLocation); ok {
+				r.sourceLocation = loc
+				continue
+			}
+		}
+		switch x := v.(type) {
+		case string:
+			r.labels[k] = x
+		case *http.Request:
+			r.httpReq = h.httpRequest(&HTTPRequest{Request: x})
+		case *HTTPRequest:
+			r.httpReq = h.httpRequest(x)
+		case *logging.HttpRequest:
+			r.httpReq = x
+		default:
+			r.labels[k] = fmt.Sprintf("%v", v)
+		}
+	}
+	if r.logName == "" {
+		r.logName = h.routeLogName(entry)
+	}
+	r.route = h.route(entry)
+	h.redact(r)
+	// qualify trace with the project id
+	if r.trace != "" && h.projectID != "" && !strings.HasPrefix(r.trace, "projects/") {
+		r.trace = "projects/" + h.projectID + "/traces/" + r.trace
+	}
+	return r
 }
 
-func (h *Hook) sendLogMessageViaAgent(entry *logrus.Entry, labels map[string]string, httpReq *logging.HttpRequest) {
+func (h *Hook) sendLogMessageViaAgent(r *record) {
+	entry := r.entry
+	h.mu.RLock()
 	// The log entry payload schema is defined by the Google fluentd
 	// logging agent. See more at:
 	// https://github.com/GoogleCloudPlatform/fluent-plugin-google-cloud
@@ -167,102 +438,251 @@ func (h *Hook) sendLogMessageViaAgent(entry *logrus.Entry, labels map[string]str
 		"timestampNanos":   strconv.FormatInt(entry.Time.UnixNano()-entry.Time.Unix()*1000000000, 10),
 		"message":          entry.Message,
 	}
-	for k, v := range labels {
+	for k, v := range r.labels {
 		logEntry[k] = v
 	}
-	if httpReq != nil {
-		logEntry["httpRequest"] = httpReq
+	if r.httpReq != nil {
+		logEntry["httpRequest"] = r.httpReq
+	}
+	if r.trace != "" {
+		logEntry[TraceField] = r.trace
+		if r.spanID != "" {
+			logEntry[SpanIDField] = r.spanID
+		}
+		logEntry[TraceSampledField] = r.traceSampled
+	}
+	if r.operation != nil {
+		logEntry[OperationField] = r.operation
+	}
+	if r.sourceLocation != nil {
+		logEntry[SourceLocationField] = r.sourceLocation
+	}
+	if r.insertID != "" {
+		logEntry[InsertIDField] = r.insertID
 	}
 	// The error reporting payload JSON schema is defined in:
 	// https://cloud.google.com/error-reporting/docs/formatting-error-messages
 	// Which reflects the structure of the ErrorEvent type in:
 	// https://godoc.org/google.golang.org/api/clouderrorreporting/v1beta1
 	if h.errorReportingServiceName != "" && isError(entry) {
-		errorEvent := h.buildErrorReportingEvent(entry, labels, httpReq)
+		errorEvent := h.buildErrorReportingEvent(r)
 		errorStructPayload, err := json.Marshal(errorEvent)
 		if err != nil {
-			log.Printf("error marshaling error reporting data: %s", err.Error())
+			h.agentError(r, fmt.Errorf("error marshaling error reporting data: %w", err))
 		}
 		var errorJSONPayload map[string]interface{}
 		err = json.Unmarshal(errorStructPayload, &errorJSONPayload)
 		if err != nil {
-			log.Printf("error parsing error reporting data: %s", err.Error())
+			h.agentError(r, fmt.Errorf("error parsing error reporting data: %w", err))
+			errorJSONPayload = make(map[string]interface{})
 		}
 		for k, v := range logEntry {
 			errorJSONPayload[k] = v
 		}
-		if err := h.agentClient.Post(h.errorReportingLogName, errorJSONPayload); err != nil {
-			log.Printf("error posting error reporting entries to logging agent: %s", err.Error())
+		h.fitAgentPayload(errorJSONPayload)
+		tag := h.errorReportingLogName
+		if r.logName != "" {
+			tag = r.logName
 		}
+		h.mu.RUnlock()
+		h.postAgent(r, tag, errorJSONPayload, "error posting error reporting entries to logging agent")
 	} else {
-		if err := h.agentClient.Post(h.logName, logEntry); err != nil {
-			log.Printf("error posting log entries to logging agent: %s", err.Error())
+		tag := h.logName
+		if r.logName != "" {
+			tag = r.logName
 		}
+		h.fitAgentPayload(logEntry)
+		h.mu.RUnlock()
+		h.postAgent(r, tag, logEntry, "error posting log entries to logging agent")
 	}
 }
 
-func (h *Hook) sendLogMessageViaAPI(entry *logrus.Entry, labels map[string]string, httpReq *logging.HttpRequest) {
+// agentError passes an error preparing the record for the logging agent to
+// the error handler.
+func (h *Hook) agentError(r *record, err error) {
+	h.handleError(&DeliveryError{
+		Transport: TransportAgent,
+		Entry:     r.entry,
+		Attempt:   1,
+		Err:       err,
+	})
+}
+
+func (h *Hook) sendLogMessageViaAPI(r *record) {
+	entry := r.entry
+	h.mu.RLock()
 	if h.errorReportingServiceName != "" && isError(entry) && !h.useLoggingServiceForErrors {
-		errorEvent := h.buildErrorReportingEvent(entry, labels, httpReq)
-		if h != nil && h.errorService != nil && h.errorService.Projects != nil && h.errorService.Projects.Events != nil {
-			_, err := h.errorService.Projects.Events.Report("projects/"+h.projectID, &errorEvent).Do()
-			if err != nil {
-				log.Println("cannot report event:", err)
-			}
-		} else {
-			log.Println("the error reporting service is not set")
-		}
+		event := h.buildErrorReportingEvent(r)
+		h.mu.RUnlock()
+		h.reportError(r, event)
 	} else {
-		logName := h.logName
-		if h.errorReportingLogName != "" && isError(entry) {
-			logName = h.errorReportingLogName
+		dest := destination{
+			logName:     h.logName,
+			projectID:   r.route.ProjectID,
+			resource:    resourceKey(r.route.Resource),
+			credentials: r.route.Credentials,
 		}
+		switch {
+		case r.logName != "":
+			dest.logName = logPath(dest.projectID, r.logName)
+		case h.errorReportingLogName != "" && isError(entry):
+			dest.logName = h.errorReportingLogName
+		}
+		if dest.projectID != h.projectID {
+			dest.logName = retarget(dest.logName, dest.projectID)
+		}
+		h.sanitizeLabels(r)
+		textPayload, jsonPayload := h.payload(r)
+		entries := h.fitEntry(&logging.LogEntry{
+			Severity:       severityString(entry.Level),
+			Timestamp:      entry.Time.Format(time.RFC3339Nano),
+			TextPayload:    textPayload,
+			Labels:         r.labels,
+			HttpRequest:    r.httpReq,
+			JsonPayload:    jsonPayload,
+			Trace:          r.trace,
+			SpanId:         r.spanID,
+			TraceSampled:   r.traceSampled,
+			Operation:      r.operation,
+			SourceLocation: r.sourceLocation,
+			InsertId:       r.insertID,
+		})
+		h.mu.RUnlock()
+		records := make([]*record, len(entries))
+		for i := range records {
+			records[i] = r
+		}
+		if h.batcher != nil {
+			h.batcher.add(dest, entries, records)
+		} else {
+			h.write(dest, entries, records)
+		}
+	}
+}
 
-		var jsonPayload googleapi.RawMessage = nil
-		var textPayload string = entry.Message
-		if h.useLoggingServiceForErrors && isError(entry) {
-			errorEvent := h.buildErrorReportingEvent(entry, labels, httpReq)
-			// See https://cloud.google.com/error-reporting/docs/formatting-error-messages
-			toMarshal := struct {
-				errorReporting.ReportedErrorEvent
-				// https://cloud.google.com/error-reporting/docs/formatting-error-messages#@type
-				Type string `json:"@type"`
-			}{
-				ReportedErrorEvent: errorEvent,
-				Type:               "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent",
+// report reports the error event to the error reporting service. The record
+// is nil for follow-up reports of repeated errors.
+func (h *Hook) report(entry *logrus.Entry, event errorReporting.ReportedErrorEvent, r *record) {
+	h.mu.RLock()
+	rt := h.route(entry)
+	h.mu.RUnlock()
+	_, errorService, err := h.services(rt.Credentials)
+	if err != nil {
+		h.stats.failed.Add(1)
+		if r != nil && h.failed(r, err) {
+			return
+		}
+		h.handleError(&DeliveryError{
+			Transport: TransportErrorReporting,
+			Entry:     entry,
+			Attempt:   1,
+			Dropped:   true,
+			Reason:    DropReportFailed,
+			Err:       fmt.Errorf("cannot report event: %w", err),
+		})
+		return
+	}
+	if errorService != nil && errorService.Projects != nil && errorService.Projects.Events != nil {
+		err := h.reportBreaker.allow(time.Now())
+		if err == nil {
+			ctx, cancel := h.callContext(h.reportTimeout)
+			_, err = errorService.Projects.Events.Report("projects/"+rt.ProjectID, &event).Context(ctx).Do()
+			cancel()
+			h.reportBreaker.done(err, time.Now())
+		}
+		if err != nil {
+			h.stats.failed.Add(1)
+			if r != nil && h.failed(r, err) {
+				return
 			}
-			var err error
-			jsonPayload, err = json.Marshal(toMarshal)
-			if err != nil {
-				log.Println("cannot marshal log entry:", err)
-			} else {
-				textPayload = ""
+			h.handleError(&DeliveryError{
+				Transport: TransportErrorReporting,
+				Entry:     entry,
+				Attempt:   1,
+				Dropped:   true,
+				Reason:    circuitReason(err, DropReportFailed),
+				Err:       fmt.Errorf("cannot report event: %w", err),
+			})
+		} else {
+			h.stats.reported.Add(1)
+			if r != nil {
+				h.delivered(r)
 			}
 		}
+	} else {
+		h.handleError(&DeliveryError{
+			Transport: TransportErrorReporting,
+			Entry:     entry,
+			Dropped:   true,
+			Reason:    DropNotConfigured,
+			Err:       errors.New("the error reporting service is not set"),
+		})
+	}
+}
 
-		_, err := h.service.Write(&logging.WriteLogEntriesRequest{
-			LogName:        logName,
-			Resource:       h.resource,
-			Labels:         h.labels,
-			PartialSuccess: h.partialSuccess,
-			Entries: []*logging.LogEntry{
-				{
-					Severity:    severityString(entry.Level),
-					Timestamp:   entry.Time.Format(time.RFC3339Nano),
-					TextPayload: textPayload,
-					Labels:      labels,
-					HttpRequest: httpReq,
-					JsonPayload: jsonPayload,
-				},
-			},
-		}).Do()
-		if err != nil {
-			log.Println("cannot deliver log entry:", err)
+// payload returns the text or JSON payload for the record. A JSON payload is
+// returned when the record's error is reported via the logging service, or
+// when the record has overflow labels.
+func (h *Hook) payload(r *record) (string, googleapi.RawMessage) {
+	entry := r.entry
+	var payload interface{}
+	switch {
+	case h.useLoggingServiceForErrors && isError(entry):
+		errorEvent := h.buildErrorReportingEvent(r)
+		// See https://cloud.google.com/error-reporting/docs/formatting-error-messages
+		payload = struct {
+			errorReporting.ReportedErrorEvent
+			// https://cloud.google.com/error-reporting/docs/formatting-error-messages#@type
+			Type string `json:"@type"`
+		}{
+			ReportedErrorEvent: errorEvent,
+			Type:               "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent",
+		}
+	case len(r.overflow) != 0:
+		payload = map[string]interface{}{
+			"message": entry.Message,
 		}
+	default:
+		return entry.Message, nil
+	}
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		h.payloadError(r, err)
+		return entry.Message, nil
+	}
+	if len(r.overflow) == 0 {
+		return "", jsonPayload
 	}
+	// add overflow labels
+	var m map[string]interface{}
+	if err := json.Unmarshal(jsonPayload, &m); err != nil {
+		h.payloadError(r, err)
+		return entry.Message, nil
+	}
+	for k, v := range r.overflow {
+		if _, ok := m[k]; !ok {
+			m[k] = v
+		}
+	}
+	if jsonPayload, err = json.Marshal(m); err != nil {
+		h.payloadError(r, err)
+		return entry.Message, nil
+	}
+	return "", jsonPayload
 }
 
-func (h *Hook) buildErrorReportingEvent(entry *logrus.Entry, labels map[string]string, httpReq *logging.HttpRequest) errorReporting.ReportedErrorEvent {
+// payloadError passes an error marshaling the record's JSON payload to the
+// error handler. The record is delivered with a text payload instead.
+func (h *Hook) payloadError(r *record, err error) {
+	h.handleError(&DeliveryError{
+		Transport: TransportAPI,
+		Entry:     r.entry,
+		Err:       fmt.Errorf("cannot marshal log entry: %w", err),
+	})
+}
+
+func (h *Hook) buildErrorReportingEvent(r *record) errorReporting.ReportedErrorEvent {
+	entry, labels, httpReq := r.entry, r.labels, r.httpReq
 	errorEvent := errorReporting.ReportedErrorEvent{
 		EventTime: entry.Time.Format(time.RFC3339),
 		Message:   entry.Message,
@@ -274,10 +694,16 @@ func (h *Hook) buildErrorReportingEvent(entry *logrus.Entry, labels map[string]s
 			User: labels["user"],
 		},
 	}
-	// Assumes that caller stack frame information of type
-	// github.com/facebookgo/stack.Frame has been added.
-	// Possibly via a library like github.com/Gurpartap/logrus-stack
-	if entry.Data["caller"] != nil {
+	// Use the source location when set. Otherwise, assumes that caller stack
+	// frame information of type github.com/facebookgo/stack.Frame has been
+	// added. Possibly via a library like github.com/Gurpartap/logrus-stack
+	if loc := r.sourceLocation; loc != nil {
+		errorEvent.Context.ReportLocation = &errorReporting.SourceLocation{
+			FilePath:     loc.File,
+			FunctionName: loc.Function,
+			LineNumber:   loc.Line,
+		}
+	} else if entry.Data["caller"] != nil {
 		caller := entry.Data["caller"].(stack.Frame)
 		errorEvent.Context.ReportLocation = &errorReporting.SourceLocation{
 			FilePath:     caller.File,
@@ -287,11 +713,12 @@ func (h *Hook) buildErrorReportingEvent(entry *logrus.Entry, labels map[string]s
 	}
 	if httpReq != nil {
 		errRepHttpRequest := &errorReporting.HttpRequestContext{
-			Method:    httpReq.RequestMethod,
-			Referrer:  httpReq.Referer,
-			RemoteIp:  httpReq.RemoteIp,
-			Url:       httpReq.RequestUrl,
-			UserAgent: httpReq.UserAgent,
+			Method:             httpReq.RequestMethod,
+			Referrer:           httpReq.Referer,
+			RemoteIp:           httpReq.RemoteIp,
+			Url:                httpReq.RequestUrl,
+			UserAgent:          httpReq.UserAgent,
+			ResponseStatusCode: httpReq.Status,
 		}
 		errorEvent.Context.HttpRequest = errRepHttpRequest
 	}
@@ -316,6 +743,11 @@ func copyEntry(entry *logrus.Entry) *logrus.Entry {
 	e := *entry
 	e.Data = make(logrus.Fields, len(entry.Data))
 	for k, v := range entry.Data {
+		// resolve the operation's markers when the entry is fired, so that
+		// they reflect the order in which entries were logged
+		if op, ok := v.(*Operation); ok && k == OperationField {
+			v = op.next()
+		}
 		e.Data[k] = v
 	}
 	return &e
@@ -331,3 +763,12 @@ func severityString(l logrus.Level) string {
 		return strings.ToUpper(l.String())
 	}
 }
+
+// randomID returns a random hex encoded id of n bytes.
+func randomID(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,357 @@
+package sdhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"google.golang.org/api/googleapi"
+	logging "google.golang.org/api/logging/v2"
+	"google.golang.org/api/option"
+)
+
+// fakeLogging is a fake logging API, recording the write requests.
+type fakeLogging struct {
+	t *testing.T
+	// respond returns the status code and body of the response to the n-th
+	// write request, counting from 0. A nil respond accepts all writes.
+	respond func(n int, req *logging.WriteLogEntriesRequest) (int, string)
+
+	mu   sync.Mutex
+	reqs []*logging.WriteLogEntriesRequest
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (f *fakeLogging) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := new(logging.WriteLogEntriesRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		f.t.Errorf("expected no error decoding write request, got: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	n := len(f.reqs)
+	f.reqs = append(f.reqs, req)
+	f.mu.Unlock()
+	code, body := http.StatusOK, "{}"
+	if f.respond != nil {
+		code, body = f.respond(n, req)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	io.WriteString(w, body)
+}
+
+// requests returns the recorded write requests.
+func (f *fakeLogging) requests() []*logging.WriteLogEntriesRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*logging.WriteLogEntriesRequest(nil), f.reqs...)
+}
+
+// newTestHook creates a hook writing to the fake logging API, with the
+// options.
+func newTestHook(t *testing.T, f *fakeLogging, opts ...Option) *Hook {
+	t.Helper()
+	f.t = t
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	service, err := logging.NewService(context.Background(), option.WithEndpoint(srv.URL), option.WithHTTPClient(srv.Client()))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	h, err := New(append([]Option{
+		LoggingService(service),
+		ProjectID("project"),
+		LogName("test"),
+		Resource(ResTypeGlobal, nil),
+	}, opts...)...)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	return h
+}
+
+// newTestLogger creates a logger firing the hook.
+func newTestLogger(h *Hook) *logrus.Logger {
+	l := logrus.New()
+	l.Out = io.Discard
+	l.Level = logrus.TraceLevel
+	l.AddHook(h)
+	return l
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		level   logrus.Level
+		fields  logrus.Fields
+		logName string
+		exp     logging.LogEntry
+	}{
+		{
+			"info", logrus.InfoLevel, nil,
+			"projects/project/logs/test",
+			logging.LogEntry{Severity: "INFO", TextPayload: "message"},
+		},
+		{
+			"error", logrus.ErrorLevel, nil,
+			"projects/project/logs/test_errors",
+			logging.LogEntry{Severity: "ERROR", TextPayload: "message"},
+		},
+		{
+			"labels", logrus.WarnLevel, logrus.Fields{"a": "b", "n": 1},
+			"projects/project/logs/test",
+			logging.LogEntry{Severity: "WARNING", TextPayload: "message", Labels: map[string]string{"a": "b", "n": "1"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := new(fakeLogging)
+			h := newTestHook(t, f)
+			newTestLogger(h).WithFields(test.fields).Log(test.level, "message")
+			h.Wait()
+			reqs := f.requests()
+			if len(reqs) != 1 || len(reqs[0].Entries) != 1 {
+				t.Fatalf("expected 1 write of 1 entry, got: %d", len(reqs))
+			}
+			if reqs[0].LogName != test.logName {
+				t.Errorf("expected log name %q, got: %q", test.logName, reqs[0].LogName)
+			}
+			le := reqs[0].Entries[0]
+			if le.Severity != test.exp.Severity {
+				t.Errorf("expected severity %q, got: %q", test.exp.Severity, le.Severity)
+			}
+			if le.TextPayload != test.exp.TextPayload {
+				t.Errorf("expected text payload %q, got: %q", test.exp.TextPayload, le.TextPayload)
+			}
+			for k, v := range test.exp.Labels {
+				if le.Labels[k] != v {
+					t.Errorf("expected label %s=%q, got: %q", k, v, le.Labels[k])
+				}
+			}
+			if le.InsertId == "" {
+				t.Errorf("expected insert id to be set")
+			}
+			if s := h.Stats(); s.Written != 1 || s.Failed != 0 {
+				t.Errorf("expected 1 written and 0 failed, got: %d and %d", s.Written, s.Failed)
+			}
+		})
+	}
+}
+
+func TestWriteFailed(t *testing.T) {
+	tests := []struct {
+		name string
+		code int
+	}{
+		{"bad request", http.StatusBadRequest},
+		{"forbidden", http.StatusForbidden},
+		{"unavailable", http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := &fakeLogging{
+				respond: func(int, *logging.WriteLogEntriesRequest) (int, string) {
+					return test.code, fmt.Sprintf(`{"error":{"code":%d,"message":"failed"}}`, test.code)
+				},
+			}
+			var mu sync.Mutex
+			var errs []*DeliveryError
+			h := newTestHook(t, f, ErrorHandler(func(err *DeliveryError) {
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, err)
+			}))
+			newTestLogger(h).Info("message")
+			h.Wait()
+			mu.Lock()
+			defer mu.Unlock()
+			if len(errs) != 1 {
+				t.Fatalf("expected 1 delivery error, got: %d", len(errs))
+			}
+			if errs[0].Reason != DropWriteFailed || !errs[0].Dropped {
+				t.Errorf("expected dropped with reason %q, got: %q", DropWriteFailed, errs[0].Reason)
+			}
+			var gerr *googleapi.Error
+			if !errors.As(errs[0], &gerr) || gerr.Code != test.code {
+				t.Errorf("expected api error with code %d, got: %v", test.code, errs[0])
+			}
+			if s := h.Stats(); s.Written != 0 || s.Failed != 1 {
+				t.Errorf("expected 0 written and 1 failed, got: %d and %d", s.Written, s.Failed)
+			}
+		})
+	}
+}
+
+func TestLevels(t *testing.T) {
+	f := new(fakeLogging)
+	h := newTestHook(t, f, Levels(logrus.ErrorLevel))
+	l := newTestLogger(h)
+	l.Info("info")
+	l.Error("error")
+	h.Wait()
+	if n := len(f.requests()); n != 1 {
+		t.Fatalf("expected 1 write, got: %d", n)
+	}
+	if err := h.Update(Levels(logrus.InfoLevel)); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	l.Info("info")
+	l.Error("error")
+	h.Wait()
+	reqs := f.requests()
+	if len(reqs) != 2 {
+		t.Fatalf("expected 2 writes, got: %d", len(reqs))
+	}
+	if s := reqs[1].Entries[0].Severity; s != "INFO" {
+		t.Errorf("expected severity INFO, got: %q", s)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		err     bool
+		logName string
+		errName string
+	}{
+		{"project", []Option{ProjectID("other")}, false, "projects/other/logs/test", "projects/other/logs/test_errors"},
+		{"log name", []Option{LogName("app")}, false, "projects/project/logs/app", "projects/project/logs/app_errors"},
+		{"project and log name", []Option{LogName("app"), ProjectID("other")}, false, "projects/other/logs/app", "projects/other/logs/app_errors"},
+		{"error log name", []Option{ErrorReportingLogName("errors")}, false, "projects/project/logs/test", "errors"},
+		{"invalid", []Option{Labels(nil), PartialRetries(-1)}, true, "projects/project/logs/test", "projects/project/logs/test_errors"},
+		{"not allowed", []Option{ProjectID("other"), Batching(10, 1)}, true, "projects/project/logs/test", "projects/project/logs/test_errors"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newTestHook(t, new(fakeLogging))
+			err := h.Update(test.opts...)
+			switch {
+			case test.err && err == nil:
+				t.Fatalf("expected error")
+			case !test.err && err != nil:
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if h.logName != test.logName {
+				t.Errorf("expected log name %q, got: %q", test.logName, h.logName)
+			}
+			if h.errorReportingLogName != test.errName {
+				t.Errorf("expected error reporting log name %q, got: %q", test.errName, h.errorReportingLogName)
+			}
+			if test.err && h.batcher != nil {
+				t.Errorf("expected batcher to not be set")
+			}
+		})
+	}
+}
+
+// countingMeter is a meter counting the instruments created.
+type countingMeter struct {
+	noop.Meter
+	n int
+}
+
+func (m *countingMeter) Int64Histogram(name string, opts ...metric.Int64HistogramOption) (metric.Int64Histogram, error) {
+	m.n++
+	return m.Meter.Int64Histogram(name, opts...)
+}
+
+func (m *countingMeter) Float64Histogram(name string, opts ...metric.Float64HistogramOption) (metric.Float64Histogram, error) {
+	m.n++
+	return m.Meter.Float64Histogram(name, opts...)
+}
+
+func (m *countingMeter) Int64ObservableCounter(name string, opts ...metric.Int64ObservableCounterOption) (metric.Int64ObservableCounter, error) {
+	m.n++
+	return m.Meter.Int64ObservableCounter(name, opts...)
+}
+
+func TestUpdateRejected(t *testing.T) {
+	meter := new(countingMeter)
+	tests := []struct {
+		name  string
+		opt   Option
+		check func(*testing.T, *Hook)
+	}{
+		{
+			"expvar stats", ExpvarStats("sdhook_test_update"),
+			func(t *testing.T, _ *Hook) {
+				if expvar.Get("sdhook_test_update") != nil {
+					t.Errorf("expected expvar variable to not be published")
+				}
+			},
+		},
+		{
+			"opentelemetry meter", OpenTelemetryMeter(meter),
+			func(t *testing.T, _ *Hook) {
+				if meter.n != 0 {
+					t.Errorf("expected no instruments to be created, got: %d", meter.n)
+				}
+			},
+		},
+		{
+			"logging agent", GoogleLoggingAgent(),
+			func(t *testing.T, h *Hook) {
+				if h.agentClient != nil {
+					t.Errorf("expected agent client to not be created")
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newTestHook(t, new(fakeLogging))
+			if err := h.Update(Levels(logrus.ErrorLevel), test.opt); err == nil {
+				t.Fatalf("expected error")
+			}
+			if len(h.levels) == 1 {
+				t.Errorf("expected levels to not be changed")
+			}
+			test.check(t, h)
+		})
+	}
+}
+
+func TestExpvarStats(t *testing.T) {
+	// expvar variables cannot be removed, so the name must be unused when
+	// the test is repeated
+	name := "sdhook_test_stats"
+	for i := 1; expvar.Get(name) != nil; i++ {
+		name = fmt.Sprintf("sdhook_test_stats_%d", i)
+	}
+	if _, err := New(ExpvarStats(name)); err == nil {
+		t.Fatalf("expected error")
+	}
+	if expvar.Get(name) != nil {
+		t.Fatalf("expected expvar variable to not be published by failed New")
+	}
+	h := newTestHook(t, new(fakeLogging), ExpvarStats(name))
+	newTestLogger(h).Info("message")
+	h.Wait()
+	v := expvar.Get(name)
+	if v == nil {
+		t.Fatalf("expected expvar variable to be published")
+	}
+	var s struct{ Written uint64 }
+	if err := json.Unmarshal([]byte(v.String()), &s); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if s.Written != 1 {
+		t.Errorf("expected 1 written, got: %d", s.Written)
+	}
+	if _, err := New(ExpvarStats(name)); err == nil {
+		t.Errorf("expected error publishing the variable again")
+	}
+}
@@ -0,0 +1,302 @@
+package sdhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Sink is a transport that log entries are delivered to.
+type Sink interface {
+	// Name returns the name of the sink, used as the transport of delivery
+	// errors.
+	Name() string
+	// Send delivers the log entry. The entry's fields are the redacted
+	// labels, along with the special fields (such as TraceField) and
+	// HTTPRequestField.
+	Send(entry *logrus.Entry) error
+}
+
+// recordSink is a sink delivering the hook's records. Record sinks handle
+// their own delivery errors.
+type recordSink interface {
+	Sink
+	send(h *Hook, r *record)
+}
+
+// sinkFilter is a sink and the levels it receives.
+type sinkFilter struct {
+	sink   Sink
+	levels []logrus.Level
+}
+
+// enabled returns true if the sink receives log entries at the level.
+func (s sinkFilter) enabled(level logrus.Level) bool {
+	if len(s.levels) == 0 {
+		return true
+	}
+	for _, l := range s.levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// errHookSink is the error returned when sending directly to one of the
+// hook's sinks.
+var errHookSink = errors.New("sink can only be used with a hook")
+
+// apiSink is the logging API sink.
+type apiSink struct{}
+
+// APISink returns a sink delivering log entries to the logging API, and
+// errors to the error reporting API, using the hook's services.
+func APISink() Sink {
+	return apiSink{}
+}
+
+// Name satisfies the Sink interface.
+func (apiSink) Name() string {
+	return TransportAPI
+}
+
+// Send satisfies the Sink interface.
+func (apiSink) Send(*logrus.Entry) error {
+	return errHookSink
+}
+
+// send satisfies the recordSink interface.
+func (apiSink) send(h *Hook, r *record) {
+	h.sendLogMessageViaAPI(r)
+}
+
+// agentSink is the logging agent sink.
+type agentSink struct{}
+
+// AgentSink returns a sink delivering log entries to the logging agent set
+// with the GoogleLoggingAgent option. Log entries are delivered
+// asynchronously, and delivery failures are reported once the logging
+// agent's client gives up retrying. In a failover chain, the sink is probed
+// by connecting to the logging agent.
+func AgentSink() Sink {
+	return agentSink{}
+}
+
+// Name satisfies the Sink interface.
+func (agentSink) Name() string {
+	return TransportAgent
+}
+
+// Send satisfies the Sink interface.
+func (agentSink) Send(*logrus.Entry) error {
+	return errHookSink
+}
+
+// send satisfies the recordSink interface.
+func (agentSink) send(h *Hook, r *record) {
+	h.sendLogMessageViaAgent(r)
+}
+
+// jsonSink is a sink writing structured JSON log entries.
+type jsonSink struct {
+	name string
+	mu   sync.Mutex
+	w    io.Writer
+	// closer closes the writer, when owned by the sink.
+	closer io.Closer
+}
+
+// JSONSink returns a sink writing log entries to w as lines of JSON, in the
+// structured logging format recognized by the logging agent and Cloud Run.
+// See https://cloud.google.com/logging/docs/structured-logging
+func JSONSink(w io.Writer) Sink {
+	return &jsonSink{
+		name: "json",
+		w:    w,
+	}
+}
+
+// StdoutSink returns a sink writing log entries to standard output as lines
+// of JSON (see JSONSink).
+func StdoutSink() Sink {
+	return &jsonSink{
+		name: "stdout",
+		w:    os.Stdout,
+	}
+}
+
+// Name satisfies the Sink interface.
+func (s *jsonSink) Name() string {
+	return s.name
+}
+
+// Send satisfies the Sink interface.
+func (s *jsonSink) Send(entry *logrus.Entry) error {
+	m := make(map[string]interface{}, len(entry.Data)+3)
+	for k, v := range entry.Data {
+		m[k] = v
+	}
+	m["severity"] = severityString(entry.Level)
+	m["time"] = entry.Time.Format(time.RFC3339Nano)
+	m["message"] = entry.Message
+	return s.write(m)
+}
+
+// send satisfies the recordSink interface.
+func (s *jsonSink) send(h *Hook, r *record) {
+	m := map[string]interface{}{
+		"severity": severityString(r.entry.Level),
+		"time":     r.entry.Time.Format(time.RFC3339Nano),
+		"message":  r.entry.Message,
+	}
+	if len(r.labels) != 0 {
+		m["logging.googleapis.com/labels"] = r.labels
+	}
+	if r.httpReq != nil {
+		m["httpRequest"] = r.httpReq
+	}
+	if r.trace != "" {
+		m[TraceField] = r.trace
+		if r.spanID != "" {
+			m[SpanIDField] = r.spanID
+		}
+		m[TraceSampledField] = r.traceSampled
+	}
+	if r.operation != nil {
+		m[OperationField] = r.operation
+	}
+	if r.sourceLocation != nil {
+		m[SourceLocationField] = r.sourceLocation
+	}
+	if r.insertID != "" {
+		m[InsertIDField] = r.insertID
+	}
+	if err := s.write(m); err != nil {
+		h.sinkError(s.name, r, err)
+		return
+	}
+	h.stats.written.Add(1)
+	h.delivered(r)
+}
+
+// Close satisfies the io.Closer interface.
+func (s *jsonSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closer.Close()
+}
+
+// write writes the log entry as a line of JSON.
+func (s *jsonSink) write(m map[string]interface{}) error {
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(buf, '\n'))
+	return err
+}
+
+// usesSink returns whether the sink delivers to the logging API or the
+// logging agent.
+func usesSink(sink Sink) (api, agent bool) {
+	switch s := sink.(type) {
+	case apiSink:
+		return true, false
+	case agentSink:
+		return false, true
+	case *failoverSink:
+		for _, sink := range s.sinks {
+			a, b := usesSink(sink)
+			api, agent = api || a, agent || b
+		}
+	}
+	return api, agent
+}
+
+// deliver delivers the record to each sink receiving its level, or to the
+// logging agent or API when no sinks are set.
+func (h *Hook) deliver(r *record) {
+	if len(h.sinks) == 0 {
+		if h.agentClient != nil {
+			h.sendLogMessageViaAgent(r)
+		} else {
+			h.sendLogMessageViaAPI(r)
+		}
+		return
+	}
+	for _, s := range h.sinks {
+		if !s.enabled(r.entry.Level) {
+			continue
+		}
+		if rs, ok := s.sink.(recordSink); ok {
+			// sinks may modify the record
+			rc := *r
+			rs.send(h, &rc)
+			continue
+		}
+		if err := s.sink.Send(r.sinkEntry()); err != nil {
+			h.sinkError(s.sink.Name(), r, err)
+			continue
+		}
+		h.stats.written.Add(1)
+	}
+}
+
+// sinkError passes an error delivering the record to the sink to the error
+// handler.
+func (h *Hook) sinkError(name string, r *record, err error) {
+	h.stats.failed.Add(1)
+	if h.failed(r, err) {
+		return
+	}
+	h.handleError(&DeliveryError{
+		Transport: name,
+		Entry:     r.entry,
+		Attempt:   1,
+		Dropped:   true,
+		Reason:    DropSinkFailed,
+		Err:       fmt.Errorf("cannot deliver log entry to sink %s: %w", name, err),
+	})
+}
+
+// sinkEntry returns the logrus entry for the record, with the redacted
+// labels and special fields as the entry's fields.
+func (r *record) sinkEntry() *logrus.Entry {
+	e := *r.entry
+	e.Data = make(logrus.Fields, len(r.labels)+7)
+	for k, v := range r.labels {
+		e.Data[k] = v
+	}
+	if r.httpReq != nil {
+		e.Data[HTTPRequestField] = r.httpReq
+	}
+	if r.trace != "" {
+		e.Data[TraceField] = r.trace
+		if r.spanID != "" {
+			e.Data[SpanIDField] = r.spanID
+		}
+		e.Data[TraceSampledField] = r.traceSampled
+	}
+	if r.operation != nil {
+		e.Data[OperationField] = r.operation
+	}
+	if r.sourceLocation != nil {
+		e.Data[SourceLocationField] = r.sourceLocation
+	}
+	if r.insertID != "" {
+		e.Data[InsertIDField] = r.insertID
+	}
+	return &e
+}
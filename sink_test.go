@@ -0,0 +1,75 @@
+package sdhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	logging "google.golang.org/api/logging/v2"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// Write satisfies the io.Writer interface.
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Bytes returns the buffered bytes.
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func TestJSONSink(t *testing.T) {
+	var buf syncBuffer
+	h, err := New(AddSink(JSONSink(&buf)))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	op := NewOperation("op", "test")
+	newTestLogger(h).WithFields(map[string]interface{}{
+		"a":                 "b",
+		TraceField:          "trace",
+		SourceLocationField: &logging.LogEntrySourceLocation{File: "main.go", Line: 10, Function: "main.main"},
+	}).WithField(OperationField, op).Warn("message")
+	h.Wait()
+	var m struct {
+		Severity       string                          `json:"severity"`
+		Message        string                          `json:"message"`
+		Labels         map[string]string               `json:"logging.googleapis.com/labels"`
+		Trace          string                          `json:"logging.googleapis.com/trace"`
+		Operation      *logging.LogEntryOperation      `json:"logging.googleapis.com/operation"`
+		SourceLocation *logging.LogEntrySourceLocation `json:"logging.googleapis.com/sourceLocation"`
+		InsertID       string                          `json:"logging.googleapis.com/insertId"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if m.Severity != "WARNING" || m.Message != "message" {
+		t.Errorf("expected WARNING message, got: %s %q", m.Severity, m.Message)
+	}
+	if m.Labels["a"] != "b" {
+		t.Errorf("expected label a=b, got: %v", m.Labels)
+	}
+	if m.Trace != "trace" {
+		t.Errorf("expected trace %q, got: %q", "trace", m.Trace)
+	}
+	if m.Operation == nil || m.Operation.Id != "op" || !m.Operation.First {
+		t.Errorf("expected first entry of operation op, got: %+v", m.Operation)
+	}
+	if m.SourceLocation == nil || m.SourceLocation.File != "main.go" || m.SourceLocation.Line != 10 || m.SourceLocation.Function != "main.main" {
+		t.Errorf("expected source location main.go:10 main.main, got: %+v", m.SourceLocation)
+	}
+	if m.InsertID == "" {
+		t.Errorf("expected insert id to be set")
+	}
+}
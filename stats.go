@@ -0,0 +1,347 @@
+package sdhook
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Drop reasons used in delivery errors and stats.
+const (
+	// DropWriteFailed is the drop reason for log entries that could not be
+	// written to the logging API.
+	DropWriteFailed = "write_failed"
+	// DropRejected is the drop reason for log entries rejected by the
+	// logging API.
+	DropRejected = "rejected"
+	// DropAgentFailed is the drop reason for log entries that could not be
+	// delivered to the logging agent.
+	DropAgentFailed = "agent_failed"
+	// DropReportFailed is the drop reason for errors that could not be
+	// reported to the error reporting API.
+	DropReportFailed = "report_failed"
+	// DropNotConfigured is the drop reason for errors that could not be
+	// reported because the error reporting service is not set.
+	DropNotConfigured = "not_configured"
+	// DropSinkFailed is the drop reason for log entries that could not be
+	// delivered to a sink.
+	DropSinkFailed = "sink_failed"
+	// DropCircuitOpen is the drop reason for log entries and errors
+	// short-circuited by an open circuit breaker.
+	DropCircuitOpen = "circuit_open"
+	// DropClosed is the drop reason for log entries fired after the hook
+	// was closed.
+	DropClosed = "closed"
+	// DropSampled is the drop reason for log entries suppressed by
+	// sampling.
+	DropSampled = "sampled"
+	// DropRateLimited is the drop reason for log entries suppressed by a
+	// rate limit.
+	DropRateLimited = "rate_limited"
+	// DropDuplicate is the drop reason for repeated errors collapsed by
+	// error deduplication.
+	DropDuplicate = "duplicate"
+)
+
+// Histogram bucket boundaries.
+var (
+	// batchSizeBounds are the bucket boundaries of the batch size histogram.
+	batchSizeBounds = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}
+	// writeLatencyBounds are the bucket boundaries of the write latency
+	// histogram, in seconds.
+	writeLatencyBounds = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// Stats is a snapshot of the hook's delivery statistics.
+type Stats struct {
+	// Fired is the number of logrus entries fired.
+	Fired uint64
+	// Written is the number of log entries written to the logging API,
+	// posted to the logging agent or delivered to other sinks.
+	Written uint64
+	// Reported is the number of errors reported to the error reporting API.
+	Reported uint64
+	// Failed is the number of failed deliveries of log entries, counting
+	// each log entry of a failed write, and each error report.
+	Failed uint64
+	// Retried is the number of log entries written again after being
+	// rejected.
+	Retried uint64
+	// Delivered is the number of log entries delivered by each sink of
+	// failover chains, by sink name.
+	Delivered map[string]uint64
+	// Failovers is the number of log entries passed to the next sink of a
+	// failover chain after a failed delivery.
+	Failovers uint64
+	// Dropped is the number of log entries dropped, by drop reason.
+	Dropped map[string]uint64
+	// QueueDepth is the number of fired logrus entries not yet delivered.
+	QueueDepth int64
+	// BatchSizes is the histogram of the number of log entries per write.
+	BatchSizes Histogram
+	// WriteLatency is the histogram of write latencies, in seconds.
+	WriteLatency Histogram
+	// WriteCircuit is the state of the circuit breaker for writes to the
+	// logging API.
+	WriteCircuit CircuitState
+	// ReportCircuit is the state of the circuit breaker for reports to the
+	// error reporting API.
+	ReportCircuit CircuitState
+	// Labels are the label statistics.
+	Labels LabelStats
+}
+
+// Histogram is a snapshot of a histogram.
+type Histogram struct {
+	// Count is the number of observations.
+	Count uint64
+	// Sum is the sum of the observations.
+	Sum float64
+	// Bounds are the upper bounds of the buckets.
+	Bounds []float64
+	// Counts are the number of observations per bucket. Counts has one more
+	// element than Bounds, counting the observations above the last bound.
+	Counts []uint64
+}
+
+// histogram is a histogram.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// newHistogram creates a histogram with the bucket boundaries.
+func newHistogram(bounds []float64) histogram {
+	return histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// observe adds the value to the histogram.
+func (hist *histogram) observe(v float64) {
+	i := 0
+	for ; i < len(hist.bounds) && hist.bounds[i] < v; i++ {
+	}
+	hist.counts[i]++
+	hist.count++
+	hist.sum += v
+}
+
+// snapshot returns a snapshot of the histogram.
+func (hist *histogram) snapshot() Histogram {
+	return Histogram{
+		Count:  hist.count,
+		Sum:    hist.sum,
+		Bounds: append([]float64(nil), hist.bounds...),
+		Counts: append([]uint64(nil), hist.counts...),
+	}
+}
+
+// stats holds the hook's delivery statistics.
+type stats struct {
+	fired      atomic.Uint64
+	written    atomic.Uint64
+	reported   atomic.Uint64
+	failed     atomic.Uint64
+	retried    atomic.Uint64
+	failovers  atomic.Uint64
+	queueDepth atomic.Int64
+
+	mu           sync.Mutex
+	delivered    map[string]uint64
+	dropped      map[string]uint64
+	batchSizes   histogram
+	writeLatency histogram
+
+	// batchSizeHist and writeLatencyHist are the OpenTelemetry histograms.
+	batchSizeHist    metric.Int64Histogram
+	writeLatencyHist metric.Float64Histogram
+}
+
+// newStats creates the delivery statistics.
+func newStats() *stats {
+	return &stats{
+		delivered:    make(map[string]uint64),
+		dropped:      make(map[string]uint64),
+		batchSizes:   newHistogram(batchSizeBounds),
+		writeLatency: newHistogram(writeLatencyBounds),
+	}
+}
+
+// drop counts a dropped log entry.
+func (s *stats) drop(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropped[reason]++
+}
+
+// deliver counts a log entry delivered by the sink of a failover chain.
+func (s *stats) deliver(sink string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delivered[sink]++
+}
+
+// write records a write of n log entries taking d.
+func (s *stats) write(n int, d time.Duration) {
+	s.mu.Lock()
+	s.batchSizes.observe(float64(n))
+	s.writeLatency.observe(d.Seconds())
+	s.mu.Unlock()
+	if s.batchSizeHist != nil {
+		s.batchSizeHist.Record(context.Background(), int64(n))
+		s.writeLatencyHist.Record(context.Background(), d.Seconds())
+	}
+}
+
+// Stats returns a snapshot of the hook's delivery statistics.
+func (h *Hook) Stats() Stats {
+	h.stats.mu.Lock()
+	defer h.stats.mu.Unlock()
+	delivered := make(map[string]uint64, len(h.stats.delivered))
+	for k, v := range h.stats.delivered {
+		delivered[k] = v
+	}
+	dropped := make(map[string]uint64, len(h.stats.dropped))
+	for k, v := range h.stats.dropped {
+		dropped[k] = v
+	}
+	return Stats{
+		Fired:         h.stats.fired.Load(),
+		Written:       h.stats.written.Load(),
+		Reported:      h.stats.reported.Load(),
+		Failed:        h.stats.failed.Load(),
+		Retried:       h.stats.retried.Load(),
+		Delivered:     delivered,
+		Failovers:     h.stats.failovers.Load(),
+		Dropped:       dropped,
+		QueueDepth:    h.stats.queueDepth.Load(),
+		BatchSizes:    h.stats.batchSizes.snapshot(),
+		WriteLatency:  h.stats.writeLatency.snapshot(),
+		WriteCircuit:  h.writeBreaker.current(),
+		ReportCircuit: h.reportBreaker.current(),
+		Labels:        h.LabelStats(),
+	}
+}
+
+// ExpvarStats is an option that publishes the hook's delivery statistics
+// (see Hook.Stats) as an expvar variable with the name. The variable is
+// published when New succeeds.
+func ExpvarStats(name string) Option {
+	return func(h *Hook) error {
+		if expvar.Get(name) != nil {
+			return errors.New("expvar variable " + name + " already published")
+		}
+		h.expvarName = name
+		return nil
+	}
+}
+
+// publishExpvar publishes the hook's delivery statistics as the expvar
+// variable.
+func (h *Hook) publishExpvar() error {
+	if expvar.Get(h.expvarName) != nil {
+		return errors.New("expvar variable " + h.expvarName + " already published")
+	}
+	expvar.Publish(h.expvarName, expvar.Func(func() interface{} {
+		return h.Stats()
+	}))
+	return nil
+}
+
+// OpenTelemetryMeter is an option that exports the hook's delivery
+// statistics (see Hook.Stats) as OpenTelemetry metrics created with the
+// meter.
+func OpenTelemetryMeter(meter metric.Meter) Option {
+	return func(h *Hook) error {
+		var err error
+		s := h.stats
+		if s.batchSizeHist, err = meter.Int64Histogram(
+			"sdhook.write.batch_size",
+			metric.WithDescription("Number of log entries per write."),
+			metric.WithExplicitBucketBoundaries(batchSizeBounds...),
+		); err != nil {
+			return err
+		}
+		if s.writeLatencyHist, err = meter.Float64Histogram(
+			"sdhook.write.latency",
+			metric.WithDescription("Write latency."),
+			metric.WithUnit("s"),
+			metric.WithExplicitBucketBoundaries(writeLatencyBounds...),
+		); err != nil {
+			return err
+		}
+		counters := []struct {
+			name, desc string
+			v          *atomic.Uint64
+		}{
+			{"sdhook.entries.fired", "Number of logrus entries fired.", &s.fired},
+			{"sdhook.entries.written", "Number of log entries written.", &s.written},
+			{"sdhook.errors.reported", "Number of errors reported.", &s.reported},
+			{"sdhook.entries.failed", "Number of failed log entry deliveries.", &s.failed},
+			{"sdhook.entries.retried", "Number of log entries written again.", &s.retried},
+			{"sdhook.entries.failovers", "Number of log entries passed to the next failover sink.", &s.failovers},
+		}
+		for _, c := range counters {
+			v := c.v
+			if _, err := meter.Int64ObservableCounter(
+				c.name,
+				metric.WithDescription(c.desc),
+				metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+					o.Observe(int64(v.Load()))
+					return nil
+				}),
+			); err != nil {
+				return err
+			}
+		}
+		if _, err := meter.Int64ObservableCounter(
+			"sdhook.entries.dropped",
+			metric.WithDescription("Number of log entries dropped."),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				for reason, n := range s.dropped {
+					o.Observe(int64(n), metric.WithAttributes(attribute.String("reason", reason)))
+				}
+				return nil
+			}),
+		); err != nil {
+			return err
+		}
+		if _, err := meter.Int64ObservableCounter(
+			"sdhook.entries.delivered",
+			metric.WithDescription("Number of log entries delivered by failover sinks."),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				for sink, n := range s.delivered {
+					o.Observe(int64(n), metric.WithAttributes(attribute.String("sink", sink)))
+				}
+				return nil
+			}),
+		); err != nil {
+			return err
+		}
+		if _, err := meter.Int64ObservableGauge(
+			"sdhook.queue.depth",
+			metric.WithDescription("Number of fired logrus entries not yet delivered."),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				o.Observe(s.queueDepth.Load())
+				return nil
+			}),
+		); err != nil {
+			return err
+		}
+		return nil
+	}
+}
@@ -0,0 +1,111 @@
+package sdhook
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Trace propagation header names.
+const (
+	// CloudTraceContextHeader is the Google Cloud trace context header, in
+	// the form "TRACE_ID/SPAN_ID;o=OPTIONS".
+	CloudTraceContextHeader = "X-Cloud-Trace-Context"
+	// TraceparentHeader is the W3C trace context header, in the form
+	// "VERSION-TRACE_ID-SPAN_ID-FLAGS".
+	TraceparentHeader = "Traceparent"
+)
+
+// TraceFields returns the logrus fields for the trace propagated in the
+// request headers, using the W3C traceparent header when present and the
+// X-Cloud-Trace-Context header otherwise. Returns nil when the request does
+// not carry a trace.
+func TraceFields(req *http.Request) logrus.Fields {
+	return traceFields(req.Header.Get(TraceparentHeader), req.Header.Get(CloudTraceContextHeader))
+}
+
+// traceFields returns the logrus fields for the traceparent or cloud trace
+// context header values.
+func traceFields(traceparent, cloudTraceContext string) logrus.Fields {
+	var trace, spanID string
+	var sampled, ok bool
+	if traceparent != "" {
+		trace, spanID, sampled, ok = parseTraceparent(traceparent)
+	}
+	if !ok && cloudTraceContext != "" {
+		trace, spanID, sampled, ok = parseCloudTraceContext(cloudTraceContext)
+	}
+	if !ok {
+		return nil
+	}
+	fields := logrus.Fields{
+		TraceField:        trace,
+		TraceSampledField: sampled,
+	}
+	if spanID != "" {
+		fields[SpanIDField] = spanID
+	}
+	return fields
+}
+
+// parseTraceparent parses a W3C traceparent header value.
+//
+// See: https://www.w3.org/TR/trace-context/#traceparent-header
+func parseTraceparent(s string) (string, string, bool, bool) {
+	v := strings.Split(strings.TrimSpace(s), "-")
+	if len(v) < 4 || len(v[0]) != 2 || v[0] == "ff" || len(v[1]) != 32 || len(v[2]) != 16 || len(v[3]) != 2 {
+		return "", "", false, false
+	}
+	if !isHex(v[1]) || !isHex(v[2]) || strings.Trim(v[1], "0") == "" || strings.Trim(v[2], "0") == "" {
+		return "", "", false, false
+	}
+	flags, err := strconv.ParseUint(v[3], 16, 8)
+	if err != nil {
+		return "", "", false, false
+	}
+	return strings.ToLower(v[1]), strings.ToLower(v[2]), flags&1 == 1, true
+}
+
+// parseCloudTraceContext parses a X-Cloud-Trace-Context header value. The
+// decimal span id is converted to the 16 character hex form expected by
+// Stackdriver.
+//
+// See: https://cloud.google.com/trace/docs/trace-context#legacy-http-header
+func parseCloudTraceContext(s string) (string, string, bool, bool) {
+	s = strings.TrimSpace(s)
+	var opts string
+	if i := strings.Index(s, ";"); i != -1 {
+		s, opts = s[:i], s[i+1:]
+	}
+	trace, span := s, ""
+	if i := strings.Index(s, "/"); i != -1 {
+		trace, span = s[:i], s[i+1:]
+	}
+	if len(trace) != 32 || !isHex(trace) {
+		return "", "", false, false
+	}
+	var spanID string
+	if span != "" {
+		n, err := strconv.ParseUint(span, 10, 64)
+		if err != nil {
+			return "", "", false, false
+		}
+		if n != 0 {
+			spanID = fmt.Sprintf("%016x", n)
+		}
+	}
+	return strings.ToLower(trace), spanID, opts == "o=1", true
+}
+
+// isHex returns true if s only contains hex digits.
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
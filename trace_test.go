@@ -0,0 +1,114 @@
+package sdhook
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		trace   string
+		spanID  string
+		sampled bool
+		ok      bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true, true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", false, true},
+		{"upper case", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true, true},
+		{"spaces", " 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01 ", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true, true},
+		{"future version", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03-extra", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true, true},
+		{"empty", "", "", "", false, false},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", "", false, false},
+		{"short trace", "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", "", "", false, false},
+		{"zero trace", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", "", false, false},
+		{"zero span", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", "", "", false, false},
+		{"not hex", "00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", "", "", false, false},
+		{"bad flags", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz", "", "", false, false},
+		{"missing flags", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", "", "", false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			trace, spanID, sampled, ok := parseTraceparent(test.s)
+			if ok != test.ok {
+				t.Fatalf("expected ok %t, got: %t", test.ok, ok)
+			}
+			if trace != test.trace || spanID != test.spanID || sampled != test.sampled {
+				t.Errorf("expected %q %q %t, got: %q %q %t", test.trace, test.spanID, test.sampled, trace, spanID, sampled)
+			}
+		})
+	}
+}
+
+func TestParseCloudTraceContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		trace   string
+		spanID  string
+		sampled bool
+		ok      bool
+	}{
+		{"sampled", "105445aa7843bc8bf206b12000100000/1;o=1", "105445aa7843bc8bf206b12000100000", "0000000000000001", true, true},
+		{"not sampled", "105445aa7843bc8bf206b12000100000/1;o=0", "105445aa7843bc8bf206b12000100000", "0000000000000001", false, true},
+		{"large span", "105445aa7843bc8bf206b12000100000/18446744073709551615", "105445aa7843bc8bf206b12000100000", "ffffffffffffffff", false, true},
+		{"no span", "105445aa7843bc8bf206b12000100000", "105445aa7843bc8bf206b12000100000", "", false, true},
+		{"zero span", "105445aa7843bc8bf206b12000100000/0;o=1", "105445aa7843bc8bf206b12000100000", "", true, true},
+		{"upper case", "105445AA7843BC8BF206B12000100000/1", "105445aa7843bc8bf206b12000100000", "0000000000000001", false, true},
+		{"empty", "", "", "", false, false},
+		{"short trace", "105445aa7843bc8bf206b120001000/1", "", "", false, false},
+		{"not hex", "105445aa7843bc8bf206b1200010000z/1", "", "", false, false},
+		{"bad span", "105445aa7843bc8bf206b12000100000/abc", "", "", false, false},
+		{"span overflow", "105445aa7843bc8bf206b12000100000/18446744073709551616", "", "", false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			trace, spanID, sampled, ok := parseCloudTraceContext(test.s)
+			if ok != test.ok {
+				t.Fatalf("expected ok %t, got: %t", test.ok, ok)
+			}
+			if trace != test.trace || spanID != test.spanID || sampled != test.sampled {
+				t.Errorf("expected %q %q %t, got: %q %q %t", test.trace, test.spanID, test.sampled, trace, spanID, sampled)
+			}
+		})
+	}
+}
+
+func TestTraceFields(t *testing.T) {
+	tests := []struct {
+		name              string
+		traceparent       string
+		cloudTraceContext string
+		trace             string
+	}{
+		{"none", "", "", ""},
+		{"traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "105445aa7843bc8bf206b12000100000/1", "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"cloud trace context", "", "105445aa7843bc8bf206b12000100000/1", "105445aa7843bc8bf206b12000100000"},
+		{"invalid traceparent", "invalid", "105445aa7843bc8bf206b12000100000/1", "105445aa7843bc8bf206b12000100000"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if test.traceparent != "" {
+				req.Header.Set(TraceparentHeader, test.traceparent)
+			}
+			if test.cloudTraceContext != "" {
+				req.Header.Set(CloudTraceContextHeader, test.cloudTraceContext)
+			}
+			fields := TraceFields(req)
+			if test.trace == "" {
+				if fields != nil {
+					t.Errorf("expected nil fields, got: %v", fields)
+				}
+				return
+			}
+			if fields[TraceField] != test.trace {
+				t.Errorf("expected trace %q, got: %v", test.trace, fields[TraceField])
+			}
+		})
+	}
+}
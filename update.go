@@ -0,0 +1,213 @@
+package sdhook
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// settings are the hook settings that can be changed with Update.
+type settings struct {
+	levels                     []logrus.Level
+	projectID                  string
+	logName                    string
+	errorReportingLogName      string
+	labels                     map[string]string
+	partialSuccess             bool
+	partialRetries             int
+	errorReportingServiceName  string
+	useLoggingServiceForErrors bool
+	maxLabels                  int
+	maxLabelKeySize            int
+	maxLabelValueSize          int
+	maxEntrySize               int
+	oversizeMode               OversizeMode
+	trustedProxies             []netip.Prefix
+	sampler                    *sampler
+	samplingDisabled           bool
+	rateLimits                 map[logrus.Level]*tokenBucket
+	summaryInterval            time.Duration
+	logNameField               string
+	logNameRules               []LogNameRule
+	routeRules                 []RouteRule
+	redactRules                []RedactRule
+}
+
+// save returns the hook's settings.
+func (h *Hook) save() settings {
+	rateLimits := make(map[logrus.Level]*tokenBucket, len(h.rateLimits))
+	for l, b := range h.rateLimits {
+		rateLimits[l] = b
+	}
+	return settings{
+		levels:                     h.levels,
+		projectID:                  h.projectID,
+		logName:                    h.logName,
+		errorReportingLogName:      h.errorReportingLogName,
+		labels:                     h.labels,
+		partialSuccess:             h.partialSuccess,
+		partialRetries:             h.partialRetries,
+		errorReportingServiceName:  h.errorReportingServiceName,
+		useLoggingServiceForErrors: h.useLoggingServiceForErrors,
+		maxLabels:                  h.maxLabels,
+		maxLabelKeySize:            h.maxLabelKeySize,
+		maxLabelValueSize:          h.maxLabelValueSize,
+		maxEntrySize:               h.maxEntrySize,
+		oversizeMode:               h.oversizeMode,
+		trustedProxies:             h.trustedProxies,
+		sampler:                    h.sampler,
+		samplingDisabled:           h.samplingDisabled,
+		rateLimits:                 rateLimits,
+		summaryInterval:            h.summaryInterval,
+		logNameField:               h.logNameField,
+		logNameRules:               h.logNameRules,
+		routeRules:                 h.routeRules,
+		redactRules:                h.redactRules,
+	}
+}
+
+// restore restores the hook's settings.
+func (h *Hook) restore(s settings) {
+	h.levels = s.levels
+	h.projectID = s.projectID
+	h.logName = s.logName
+	h.errorReportingLogName = s.errorReportingLogName
+	h.labels = s.labels
+	h.partialSuccess = s.partialSuccess
+	h.partialRetries = s.partialRetries
+	h.errorReportingServiceName = s.errorReportingServiceName
+	h.useLoggingServiceForErrors = s.useLoggingServiceForErrors
+	h.maxLabels = s.maxLabels
+	h.maxLabelKeySize = s.maxLabelKeySize
+	h.maxLabelValueSize = s.maxLabelValueSize
+	h.maxEntrySize = s.maxEntrySize
+	h.oversizeMode = s.oversizeMode
+	h.trustedProxies = s.trustedProxies
+	h.sampler = s.sampler
+	h.samplingDisabled = s.samplingDisabled
+	h.rateLimits = s.rateLimits
+	h.summaryInterval = s.summaryInterval
+	h.logNameField = s.logNameField
+	h.logNameRules = s.logNameRules
+	h.routeRules = s.routeRules
+	h.redactRules = s.redactRules
+}
+
+// Update changes the hook's settings at runtime, such as from an admin
+// endpoint or a SIGHUP handler. Update is safe to call concurrently with
+// logging. When an option returns an error, none of the options are applied.
+//
+// The options that can be passed to Update are Levels, ProjectID, LogName,
+// ErrorReportingLogName, Labels, PartialSuccess, PartialRetries,
+// ErrorReportingService, UseLoggingServiceForErrors, LabelLimits,
+// MaxEntrySize, TrustedProxies, Sampling, SamplingEnabled, RateLimit,
+// LogNameField, LogNameRules, Routes, Redact and ResetRules. Other options,
+// such as those creating clients, sinks or handlers, must only be passed to
+// New, and are rejected by Update before any option is applied.
+//
+// When the project is changed, the log names are moved to the project. When
+// the log name is changed and the error reporting log name was not set, the
+// error reporting log name is changed accordingly.
+func (h *Hook) Update(opts ...Option) error {
+	for i, o := range opts {
+		if !isSetting(o) {
+			return fmt.Errorf("option %d cannot be passed to Update", i)
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	prev := h.save()
+	// the options are applied to a hook holding only the settings, so that
+	// the hook is not changed when an option returns an error
+	scratch := new(Hook)
+	scratch.restore(prev.clip())
+	for _, o := range opts {
+		if err := o(scratch); err != nil {
+			return err
+		}
+	}
+	next := scratch.save()
+	derived := next.errorReportingLogName == prev.logName+"_errors"
+	if next.projectID != prev.projectID {
+		next.logName = retargetLog(next.logName, next.projectID)
+		next.errorReportingLogName = retargetLog(next.errorReportingLogName, next.projectID)
+	}
+	if derived && next.logName != prev.logName {
+		next.errorReportingLogName = next.logName + "_errors"
+	}
+	h.restore(next)
+	return nil
+}
+
+// clip returns the settings with their slices clipped to their length, so
+// that options appending to them do not change the hook's slices.
+func (s settings) clip() settings {
+	s.levels = s.levels[:len(s.levels):len(s.levels)]
+	s.trustedProxies = s.trustedProxies[:len(s.trustedProxies):len(s.trustedProxies)]
+	s.logNameRules = s.logNameRules[:len(s.logNameRules):len(s.logNameRules)]
+	s.routeRules = s.routeRules[:len(s.routeRules):len(s.routeRules)]
+	s.redactRules = s.redactRules[:len(s.redactRules):len(s.redactRules)]
+	return s
+}
+
+// retargetLog returns the full resource name of the log in the project, when
+// the log name is a full resource name.
+func retargetLog(logName, projectID string) string {
+	if projectID == "" || !strings.HasPrefix(logName, "projects/") {
+		return logName
+	}
+	return retarget(logName, projectID)
+}
+
+// setting wraps an option only changing the settings, so that it can be
+// passed to Update.
+//
+// setting must not be inlined, so that the options it returns share the
+// code pointer checked by isSetting.
+//
+//go:noinline
+func setting(o Option) Option {
+	return func(h *Hook) error {
+		return o(h)
+	}
+}
+
+// settingPointer is the code pointer of the options returned by setting.
+var settingPointer = reflect.ValueOf(setting(nil)).Pointer()
+
+// isSetting returns true when the option was wrapped by setting.
+func isSetting(o Option) bool {
+	return o != nil && reflect.ValueOf(o).Pointer() == settingPointer
+}
+
+// SamplingEnabled is an option that enables or disables the sampling set
+// with the Sampling option, such as with Update.
+func SamplingEnabled(enabled bool) Option {
+	return setting(func(h *Hook) error {
+		h.samplingDisabled = !enabled
+		return nil
+	})
+}
+
+// ResetRules is an option that removes the log name rules, routing rules,
+// redaction rules and rate limits, so that Update can replace them.
+func ResetRules() Option {
+	return setting(func(h *Hook) error {
+		h.logNameRules, h.routeRules, h.redactRules, h.rateLimits = nil, nil, nil, nil
+		return nil
+	})
+}
+
+// levelEnabled returns true if the hook's levels include the level.
+func (h *Hook) levelEnabled(level logrus.Level) bool {
+	for _, l := range h.levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
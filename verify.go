@@ -0,0 +1,214 @@
+package sdhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	errorReporting "google.golang.org/api/clouderrorreporting/v1beta1"
+	"google.golang.org/api/googleapi"
+	logging "google.golang.org/api/logging/v2"
+)
+
+// IAM permissions required by the hook.
+const (
+	// PermissionWriteEntries is the permission required to write log entries.
+	PermissionWriteEntries = "logging.logEntries.create"
+	// PermissionReportErrors is the permission required to report error
+	// events.
+	PermissionReportErrors = "errorreporting.errorEvents.create"
+)
+
+// APIs used by the hook.
+const (
+	// ServiceLogging is the logging API.
+	ServiceLogging = "logging.googleapis.com"
+	// ServiceErrorReporting is the error reporting API.
+	ServiceErrorReporting = "clouderrorreporting.googleapis.com"
+)
+
+// ServiceDisabledError is the error returned when an API used by the hook is
+// not enabled in the project.
+type ServiceDisabledError struct {
+	// Service is the disabled API, such as ServiceLogging.
+	Service string
+	// ProjectID is the project the API is not enabled in.
+	ProjectID string
+	// Err is the underlying error.
+	Err error
+}
+
+// Error satisfies the error interface.
+func (err *ServiceDisabledError) Error() string {
+	return fmt.Sprintf("api %s is not enabled in project %s: %v", err.Service, err.ProjectID, err.Err)
+}
+
+// Unwrap returns the underlying error.
+func (err *ServiceDisabledError) Unwrap() error {
+	return err.Err
+}
+
+// PermissionError is the error returned when the credentials are missing an
+// oauth2 scope or IAM permission required by the hook.
+type PermissionError struct {
+	// Scope is the missing oauth2 scope, if any.
+	Scope string
+	// Permission is the missing IAM permission, if any, such as
+	// PermissionWriteEntries.
+	Permission string
+	// ProjectID is the project the permission was checked on.
+	ProjectID string
+	// Err is the underlying error.
+	Err error
+}
+
+// Error satisfies the error interface.
+func (err *PermissionError) Error() string {
+	if err.Scope != "" {
+		return fmt.Sprintf("missing required scope %s in compute metadata", err.Scope)
+	}
+	return fmt.Sprintf("missing permission %s on project %s: %v", err.Permission, err.ProjectID, err.Err)
+}
+
+// Unwrap returns the underlying error.
+func (err *PermissionError) Unwrap() error {
+	return err.Err
+}
+
+// Verify checks that the hook's credentials can deliver log entries and
+// error reports to the hook's project, so that misconfigured service
+// accounts are found at startup rather than when logging. Verify:
+//
+//   - checks the compute metadata scopes, when using GoogleComputeCredentials
+//   - writes a log entry with DryRun set, which is validated but not stored
+//   - reports an invalid error event, which is rejected by the error
+//     reporting service after checking permissions
+//
+// All checks are run, and the failures are returned joined. Missing scopes
+// and permissions are returned as *PermissionError, and APIs not enabled in
+// the project as *ServiceDisabledError, which can be retrieved with
+// errors.As. Projects and credentials used by routing rules are not
+// checked. Error reporting is only checked when ErrorReportingService is set.
+// Verify does nothing when the hook does not use the API.
+func (h *Hook) Verify(ctx context.Context) error {
+	useAPI := len(h.sinks) == 0 && h.agentClient == nil
+	for _, s := range h.sinks {
+		api, _ := usesSink(s.sink)
+		useAPI = useAPI || api
+	}
+	if !useAPI {
+		return nil
+	}
+	var errs []error
+	if h.computeCredentials {
+		if err := checkScopes(h.computeServiceAccount); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	h.mu.RLock()
+	projectID := h.projectID
+	write := &logging.WriteLogEntriesRequest{
+		LogName:  h.logName,
+		Resource: h.resource,
+		Labels:   h.labels,
+		Entries: []*logging.LogEntry{{
+			Severity:    "INFO",
+			TextPayload: "verify",
+		}},
+		DryRun: true,
+	}
+	report := h.errorService != nil && h.errorReportingServiceName != "" && !h.useLoggingServiceForErrors
+	h.mu.RUnlock()
+	if err := h.verifyWrite(ctx, projectID, write); err != nil {
+		errs = append(errs, err)
+	}
+	if report {
+		if err := h.verifyReport(ctx, projectID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// verifyWrite writes the dry-run request.
+func (h *Hook) verifyWrite(ctx context.Context, projectID string, req *logging.WriteLogEntriesRequest) error {
+	ctx, cancel := verifyContext(ctx, h.writeTimeout)
+	defer cancel()
+	_, err := h.service.Write(req).Context(ctx).Do()
+	if err != nil {
+		return verifyError(ServiceLogging, PermissionWriteEntries, projectID, fmt.Errorf("dry-run write: %w", err))
+	}
+	return nil
+}
+
+// verifyReport reports an error event without a message. The error
+// reporting service has no dry-run mode, but checks permissions before
+// rejecting the event as invalid, so a bad request indicates the event
+// would have been accepted.
+func (h *Hook) verifyReport(ctx context.Context, projectID string) error {
+	if h.errorService.Projects == nil || h.errorService.Projects.Events == nil {
+		return errors.New("no error reporting service was provided")
+	}
+	ctx, cancel := verifyContext(ctx, h.reportTimeout)
+	defer cancel()
+	_, err := h.errorService.Projects.Events.Report("projects/"+projectID, &errorReporting.ReportedErrorEvent{}).Context(ctx).Do()
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Code == http.StatusBadRequest {
+		return nil
+	}
+	if err != nil {
+		return verifyError(ServiceErrorReporting, PermissionReportErrors, projectID, fmt.Errorf("test report: %w", err))
+	}
+	return nil
+}
+
+// verifyContext returns the context for a verify call, with the timeout, if
+// any.
+func verifyContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// verifyError returns a *ServiceDisabledError for the service when err is
+// a forbidden API error caused by the service not being enabled, a
+// *PermissionError for the permission when err is another unauthorized or
+// forbidden API error, and otherwise err.
+func verifyError(service, permission, projectID string, err error) error {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) || (gerr.Code != http.StatusUnauthorized && gerr.Code != http.StatusForbidden) {
+		return err
+	}
+	if gerr.Code == http.StatusForbidden && serviceDisabled(gerr) {
+		return &ServiceDisabledError{
+			Service:   service,
+			ProjectID: projectID,
+			Err:       err,
+		}
+	}
+	return &PermissionError{
+		Permission: permission,
+		ProjectID:  projectID,
+		Err:        err,
+	}
+}
+
+// serviceDisabled returns true if the API error's reason is that the API is
+// not enabled, from the google.rpc.ErrorInfo details or the legacy error
+// reasons.
+func serviceDisabled(gerr *googleapi.Error) bool {
+	for _, d := range gerr.Details {
+		if m, ok := d.(map[string]interface{}); ok && m["reason"] == "SERVICE_DISABLED" {
+			return true
+		}
+	}
+	for _, item := range gerr.Errors {
+		if item.Reason == "accessNotConfigured" {
+			return true
+		}
+	}
+	return false
+}
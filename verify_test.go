@@ -0,0 +1,127 @@
+package sdhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+	logging "google.golang.org/api/logging/v2"
+)
+
+// errorInfo returns the google.rpc.ErrorInfo details of an API error with
+// the reason.
+func errorInfo(reason string) map[string]interface{} {
+	return map[string]interface{}{
+		"@type":  "type.googleapis.com/google.rpc.ErrorInfo",
+		"reason": reason,
+		"domain": "googleapis.com",
+	}
+}
+
+func TestServiceDisabled(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *googleapi.Error
+		exp  bool
+	}{
+		{"no reason", &googleapi.Error{Code: http.StatusForbidden}, false},
+		{"service disabled", &googleapi.Error{Code: http.StatusForbidden, Details: []interface{}{errorInfo("SERVICE_DISABLED")}}, true},
+		{"other details", &googleapi.Error{Code: http.StatusForbidden, Details: []interface{}{"SERVICE_DISABLED", errorInfo("IAM_PERMISSION_DENIED")}}, false},
+		{"access not configured", &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "accessNotConfigured"}}}, true},
+		{"forbidden reason", &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if v := serviceDisabled(test.err); v != test.exp {
+				t.Errorf("expected %t, got: %t", test.exp, v)
+			}
+		})
+	}
+}
+
+func TestVerifyError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		disabled bool
+		denied   bool
+	}{
+		{"not api error", errors.New("failed"), false, false},
+		{"bad request", &googleapi.Error{Code: http.StatusBadRequest}, false, false},
+		{"not found", &googleapi.Error{Code: http.StatusNotFound}, false, false},
+		{"unauthorized", &googleapi.Error{Code: http.StatusUnauthorized}, false, true},
+		{"forbidden", &googleapi.Error{Code: http.StatusForbidden, Details: []interface{}{errorInfo("IAM_PERMISSION_DENIED")}}, false, true},
+		{"service disabled", &googleapi.Error{Code: http.StatusForbidden, Details: []interface{}{errorInfo("SERVICE_DISABLED")}}, true, false},
+		{"access not configured", &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "accessNotConfigured"}}}, true, false},
+		{"unauthorized service disabled", &googleapi.Error{Code: http.StatusUnauthorized, Details: []interface{}{errorInfo("SERVICE_DISABLED")}}, false, true},
+		{"wrapped", fmt.Errorf("dry-run write: %w", &googleapi.Error{Code: http.StatusForbidden, Details: []interface{}{errorInfo("SERVICE_DISABLED")}}), true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := verifyError(ServiceLogging, PermissionWriteEntries, "project", test.err)
+			if !errors.Is(err, test.err) {
+				t.Errorf("expected error to wrap %v, got: %v", test.err, err)
+			}
+			var serr *ServiceDisabledError
+			if v := errors.As(err, &serr); v != test.disabled {
+				t.Fatalf("expected service disabled %t, got: %v", test.disabled, err)
+			}
+			if test.disabled && (serr.Service != ServiceLogging || serr.ProjectID != "project") {
+				t.Errorf("expected service %s in project, got: %s in %s", ServiceLogging, serr.Service, serr.ProjectID)
+			}
+			var perr *PermissionError
+			if v := errors.As(err, &perr); v != test.denied {
+				t.Fatalf("expected permission error %t, got: %v", test.denied, err)
+			}
+			if test.denied && (perr.Permission != PermissionWriteEntries || perr.ProjectID != "project") {
+				t.Errorf("expected permission %s on project, got: %s on %s", PermissionWriteEntries, perr.Permission, perr.ProjectID)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     int
+		body     string
+		disabled bool
+		denied   bool
+	}{
+		{"ok", http.StatusOK, "{}", false, false},
+		{"denied", http.StatusForbidden, `{"error":{"code":403,"message":"denied","status":"PERMISSION_DENIED"}}`, false, true},
+		{
+			"service disabled", http.StatusForbidden,
+			`{"error":{"code":403,"message":"disabled","status":"PERMISSION_DENIED","details":[{"@type":"type.googleapis.com/google.rpc.ErrorInfo","reason":"SERVICE_DISABLED"}]}}`,
+			true, false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := &fakeLogging{
+				respond: func(_ int, req *logging.WriteLogEntriesRequest) (int, string) {
+					if !req.DryRun {
+						t.Errorf("expected dry run")
+					}
+					return test.code, test.body
+				},
+			}
+			h := newTestHook(t, f)
+			err := h.Verify(context.Background())
+			if (err != nil) != (test.disabled || test.denied) {
+				t.Fatalf("expected error %t, got: %v", test.disabled || test.denied, err)
+			}
+			var serr *ServiceDisabledError
+			if v := errors.As(err, &serr); v != test.disabled {
+				t.Errorf("expected service disabled %t, got: %v", test.disabled, err)
+			}
+			var perr *PermissionError
+			if v := errors.As(err, &perr); v != test.denied {
+				t.Errorf("expected permission error %t, got: %v", test.denied, err)
+			}
+		})
+	}
+}